@@ -11,15 +11,15 @@ import (
 
 var (
 	cleanAll         = false
-	allowedCleanArgs = []string{"cached", "sso"}
+	allowedCleanArgs = []string{"cached", "sso", "instances"}
 )
 
 var cleanCmd = &cobra.Command{
 	Use:       "clean [" + strings.Join(allowedCleanArgs, "] [") + "]",
 	Short:     "Clean sso client credentials and role credentials from cache",
-	Args:      cobra.RangeArgs(1, 2),
+	Args:      cobra.RangeArgs(1, 3),
 	ValidArgs: allowedCleanArgs,
-	Example:   "  knox clean cached\n  knox clean sso -a\n  knox clean cached sso",
+	Example:   "  knox clean cached\n  knox clean sso -a\n  knox clean cached sso\n  knox clean instances",
 	Run: func(cmd *cobra.Command, args []string) {
 		if slices.Contains(args, "cached") {
 			roles, err := credentials.GetSavedRolesWithCredentials()
@@ -59,6 +59,12 @@ var cleanCmd = &cobra.Command{
 				fmt.Println("Successfully deleted expired client credentials")
 			}
 		}
+		if slices.Contains(args, "instances") {
+			if err := credentials.DeleteCachedInstances(); err != nil {
+				ExitWithError(5, "failed to delete cached instances", err)
+			}
+			fmt.Println("Successfully deleted cached instances")
+		}
 	},
 }
 
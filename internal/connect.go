@@ -1,149 +1,152 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"strings"
-	"syscall"
 
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/channel"
 	"github.com/null93/aws-knox/sdk/credentials"
+	"github.com/null93/aws-knox/sdk/runner"
 	. "github.com/null93/aws-knox/sdk/style"
-	"github.com/null93/aws-knox/sdk/tui"
 	"github.com/spf13/cobra"
 )
 
 var connectCmd = &cobra.Command{
-	Use:   "connect [instance-search-term]",
-	Short: "Connect to an EC2 instance using session-manager-plugin",
+	Use:     "connect [instance-search-term] [-- command...]",
+	Short:   "Connect to an EC2 instance using session manager",
+	Example: "  knox connect web\n  knox connect web -- htop\n  knox connect --targets tag:Tier=api --tmux panes --sync-panes",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(connectTargets) > 0 && connectTmux == "" {
+			connectTmux = TmuxLayoutWindows
+		}
+		if connectTmux != "" && connectTmux != TmuxLayoutWindows && connectTmux != TmuxLayoutPanes {
+			return fmt.Errorf("invalid tmux layout %q, must be windows or panes", connectTmux)
+		}
+		if connectSyncPanes && connectTmux != TmuxLayoutPanes {
+			return fmt.Errorf("--sync-panes requires --tmux panes")
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		command := ""
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			args, command = args[:dash], strings.Join(args[dash:], " ")
+		}
 		searchTerm := strings.Join(args, " ")
-		currentSelector := "instance"
-		var err error
-		var role *credentials.Role
-		var action string
-		var binaryPath string
-		if lastUsed {
-			var err error
-			var sessions credentials.Sessions
-			var session *credentials.Session
-			var roleTemp credentials.Role
-			if roleTemp, err = credentials.GetLastUsedRole(); err != nil {
-				ExitWithError(1, "failed to get last used role", err)
+		if len(connectTargets) > 0 {
+			role := SelectRole()
+			if region == "" {
+				region = workloadRegion(role)
 			}
-			role = &roleTemp
-			if role.Credentials == nil || role.Credentials.IsExpired() {
-				if sessions, err = credentials.GetSessions(); err != nil {
-					ExitWithError(2, "failed to parse sso sessions", err)
-				}
-				if session = sessions.FindByName(role.SessionName); session == nil {
-					ExitWithError(3, "failed to find sso session "+role.SessionName, err)
-				}
-				if session.ClientToken == nil || session.ClientToken.IsExpired() {
-					if err = tui.ClientLogin(session); err != nil {
-						ExitWithError(4, "failed to authorize device login", err)
-					}
-				}
-				if err = session.RefreshRoleCredentials(role); err != nil {
-					ExitWithError(5, "failed to get credentials", err)
-				}
-				if err = role.Credentials.Save(session.Name, role.CacheKey()); err != nil {
-					ExitWithError(6, "failed to save credentials", err)
-				}
+			instances := resolveTargets(role, connectTargets)
+			if len(instances) < 1 {
+				ExitWithError(63, "no instances matched the passed targets", nil)
 			}
+			connectInTmux(role, instances, cmd.Flags().Changed("uid"), command)
+			return
 		}
-		for {
-			if role == nil {
-				if !selectCachedFirst || (sessionName != "" && accountId != "" && roleName != "") {
-					action, role = SelectRoleCredentialsStartingFromSession()
-				} else {
-					action, role = SelectRoleCredentialsStartingFromCache()
-				}
-				if action == "toggle-view" {
-					toggleView()
-					continue
-				}
-				if action == "back" {
-					goBack(&role)
-					continue
-				}
-				if action == "delete" {
-					if role != nil && role.Credentials != nil {
-						role.Credentials.DeleteCache(role.SessionName, role.CacheKey())
-						role = nil
-					}
-					continue
-				}
-			}
-			if region == "" {
-				region = role.Region
-			}
-			if instanceId == "" {
-				if currentSelector == "instance" {
-					if instanceId, action, err = tui.SelectInstance(role, region, searchTerm, instanceColTags); err != nil {
-						ExitWithError(19, "failed to pick an instance", err)
-					} else if action == "back" {
-						goBack(&role)
-						continue
-					} else if action == "refresh" {
-						continue
-					} else if action == "pick-region" {
-						currentSelector = "region"
-						continue
-					}
-				} else {
-					pickedRegion := ""
-					if pickedRegion, action, err = tui.SelectRegion(region); err != nil {
-						ExitWithError(20, "failed to pick a region", err)
-					} else if action == "back" {
-						currentSelector = "instance"
-						continue
-					}
-					currentSelector = "instance"
-					region = pickedRegion
-					continue
-				}
+		if connectTmux != "" {
+			role, instances := SelectRoleAndInstances(searchTerm)
+			if len(instances) < 1 {
+				instances = credentials.Instances{{Id: instanceId, Region: region}}
 			}
+			connectInTmux(role, instances, cmd.Flags().Changed("uid"), command)
+			return
+		}
+		role, instance := SelectRoleAndInstance(searchTerm)
+		if instance == nil && needsInstanceTags() {
+			instance = loadInstances(role, []string{region}, credentials.InstanceFilters{}).FindById(instanceId)
+		}
+		document := sessionDocumentFor(role, instance)
+		user := connectUserFor(cmd.Flags().Changed("uid"), role, instance)
+		parameters, err := document.render(user, connectUid, command)
+		if err != nil {
+			ExitWithError(59, "failed to prepare session document", err)
+		}
 
-			yellow := color.ToForeground(YellowColor).Decorator()
-			gray := color.ToForeground(LightGrayColor).Decorator()
-			title := TitleStyle.Decorator()
-			DefaultStyle.Printfln("")
-			DefaultStyle.Printfln("%s %s", title("SSO Session: "), gray(role.SessionName))
-			DefaultStyle.Printfln("%s %s", title("Region:      "), gray(region))
-			DefaultStyle.Printfln("%s %s", title("Account ID:  "), gray(role.AccountId))
-			DefaultStyle.Printfln("%s %s", title("Role Name:   "), gray(role.Name))
-			DefaultStyle.Printfln("%s %s", title("Instance ID: "), yellow(instanceId))
+		yellow := color.ToForeground(YellowColor).Decorator()
+		gray := color.ToForeground(LightGrayColor).Decorator()
+		title := TitleStyle.Decorator()
+		DefaultStyle.Printfln("")
+		DefaultStyle.Printfln("%s %s", title("SSO Session: "), gray(role.SessionName))
+		DefaultStyle.Printfln("%s %s", title("Region:      "), gray(region))
+		DefaultStyle.Printfln("%s %s", title("Account ID:  "), gray(role.AccountId))
+		DefaultStyle.Printfln("%s %s", title("Role Name:   "), gray(role.Name))
+		DefaultStyle.Printfln("%s %s", title("Instance ID: "), yellow(instanceId))
+		DefaultStyle.Printfln("%s %s", title("OS User:     "), gray(connectUserLabel(user, connectUid)))
+		DefaultStyle.Printfln("%s %s", title("Document:    "), gray(document.name()))
 
-			details, err := role.StartSession(instanceId, connectUid)
-			if err != nil {
-				ExitWithError(20, "failed to start ssm session", err)
-			}
-			if binaryPath, err = exec.LookPath("session-manager-plugin"); err != nil {
-				ExitWithError(21, "failed to find session-manager-plugin, see "+SESSION_MANAGER_PLUGIN_URL, err)
+		sessionRunner := newSessionRunner()
+		request := sessionRequest(role, instanceId, runner.ModeShell, func() (*ssm.StartSessionOutput, error) {
+			return role.StartSessionDocument(region, instanceId, document.Document, parameters)
+		})
+		// Recording needs the terminal stream, which the plugin never hands
+		// back, so recorded sessions always use the native backend. It
+		// rejects sessions encrypted with a KMS key, which fall back to the
+		// plugin without a recording when it was only asked for with
+		// --record, but can't be started when record_accounts enforces it.
+		if recordSession || isRecordingEnforced(role) {
+			recorder := startRecording(role)
+			DefaultStyle.Printfln("%s %s", title("Recording:   "), gray(recorder.Metadata.Name))
+			start := request.Start
+			request.Start = func() (*ssm.StartSessionOutput, error) {
+				details, err := start()
+				if err == nil {
+					recorder.Metadata.SessionId = *details.SessionId
+				}
+				return details, err
 			}
-			command := exec.Command(
-				binaryPath,
-				fmt.Sprintf(`{"SessionId": "%s", "TokenValue": "%s", "StreamUrl": "%s"}`, *details.SessionId, *details.TokenValue, *details.StreamUrl),
-				region,
-				"StartSession",
-				"", // No Profile
-				fmt.Sprintf(`{"Target": "%s"}`, instanceId),
-				fmt.Sprintf("https://ssm.%s.amazonaws.com", region),
-			)
-			command.Stdin = os.Stdin
-			command.Stdout = os.Stdout
-			command.Stderr = os.Stderr
-			command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true}
-			if err = command.Run(); err != nil {
-				ExitWithError(22, "failed to run session-manager-plugin", err)
+			request.Stdout = io.MultiWriter(os.Stdout, recorder)
+			stopWatching := recorder.WatchResize(int(os.Stdin.Fd()))
+			recording := true
+			onExit(func() {
+				if recording {
+					stopWatching()
+					recorder.Close()
+				}
+			})
+			var fallback runner.Runner
+			if !isRecordingEnforced(role) {
+				fallback = &unrecordedRunner{Runner: &runner.Plugin{}, discard: func() {
+					recording = false
+					stopWatching()
+					recorder.Discard()
+					DefaultStyle.Printfln("%s %s", title("Recording:   "), yellow("skipped, the session is encrypted with a KMS key"))
+				}}
 			}
-			break
+			sessionRunner = &runner.Native{Fallback: fallback}
+		}
+
+		_, err = sessionRunner.Run(context.Background(), request)
+		if errors.Is(err, channel.ErrEncryptionNotSupported) {
+			ExitWithError(64, "cannot record the session since it is encrypted with a KMS key, and record_accounts requires recording this account", err)
+		}
+		if err != nil {
+			exitWithSessionError(err)
 		}
+		runExitHooks()
 	},
 }
 
+// The plugin can't record, so the recording is discarded before a session
+// falls back to it, and it gets the terminal instead of the recorder.
+type unrecordedRunner struct {
+	runner.Runner
+	discard func()
+}
+
+func (u *unrecordedRunner) Run(ctx context.Context, request runner.Request) (*runner.Result, error) {
+	u.discard()
+	request.Stdout = os.Stdout
+	return u.Runner.Run(ctx, request)
+}
+
 func init() {
 	RootCmd.AddCommand(connectCmd)
 	connectCmd.Flags().SortFlags = true
@@ -151,7 +154,15 @@ func init() {
 	connectCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
 	connectCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
 	connectCmd.Flags().StringVarP(&instanceId, "instance-id", "i", instanceId, "EC2 instance ID")
-	connectCmd.Flags().StringVar(&region, "region", region, "Region for quering instances")
+	connectCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and starting sessions")
+	connectCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	connectCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
 	connectCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
 	connectCmd.Flags().Uint32VarP(&connectUid, "uid", "u", connectUid, "UID on instance to 'su' to")
+	connectCmd.Flags().StringVar(&connectUser, "user", connectUser, "User on instance to 'su' to, overrides --uid")
+	connectCmd.Flags().StringArrayVarP(&connectTargets, "targets", "t", connectTargets, "Instance ID or filter in the form name=value[,value] to open in tmux, skips the picker (repeatable)")
+	connectCmd.Flags().StringVar(&connectTmux, "tmux", connectTmux, "Open sessions in tmux windows or panes")
+	connectCmd.Flags().Lookup("tmux").NoOptDefVal = TmuxLayoutWindows
+	connectCmd.Flags().BoolVar(&connectSyncPanes, "sync-panes", connectSyncPanes, "Synchronize input across tmux panes")
+	connectCmd.Flags().BoolVar(&recordSession, "record", recordSession, "Record the session in asciinema format")
 }
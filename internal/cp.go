@@ -0,0 +1,512 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/null93/aws-knox/pkg/ansi"
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/credentials"
+	. "github.com/null93/aws-knox/sdk/style"
+	"github.com/null93/aws-knox/sdk/tui"
+	"github.com/spf13/cobra"
+)
+
+const (
+	TransfersPath = ".aws/knox/transfers"
+	cpPartSuffix  = ".knox-part"
+	// Transfers run with sh, since the minimal images cp is meant for often
+	// don't have bash.
+	cpShell = "sh"
+)
+
+var (
+	cpRecursive bool
+	cpChunkSize uint32 = 32 * 1024
+)
+
+type cpEndpoint struct {
+	remote bool
+	target string
+	path   string
+}
+
+type cpFile struct {
+	local    string
+	remote   string
+	size     int64
+	modified string
+}
+
+type cpJournal struct {
+	Completed map[int]bool `json:"completed"`
+}
+
+// Only "target:path" where the target has no slash is remote, so local paths
+// like ./a:b still work. An empty target opens the instance picker.
+func parseCpEndpoint(arg string) cpEndpoint {
+	target, remotePath, found := strings.Cut(arg, ":")
+	if !found || strings.Contains(target, "/") {
+		return cpEndpoint{path: arg}
+	}
+	return cpEndpoint{remote: true, target: target, path: remotePath}
+}
+
+func remoteOutputScript(script string) string {
+	return "echo KNOX_BEGIN; " + script + "; echo KNOX_END"
+}
+
+func remoteOutput(role *credentials.Role, script string) (string, error) {
+	result, err := runScript(role, instanceId, cpShell, remoteOutputScript(script))
+	if err != nil {
+		return "", err
+	}
+	return parseRemoteOutput(result.Output)
+}
+
+func parseRemoteOutput(output []byte) (string, error) {
+	normalized := strings.ReplaceAll(string(output), "\r\n", "\n")
+	_, content, found := strings.Cut(normalized, "KNOX_BEGIN\n")
+	if !found {
+		return "", fmt.Errorf("unexpected output from instance: %q", strings.TrimSpace(normalized))
+	}
+	content, _, found = strings.Cut(content, "KNOX_END")
+	if !found {
+		return "", fmt.Errorf("truncated output from instance")
+	}
+	return content, nil
+}
+
+func mustRemoteOutput(role *credentials.Role, script string) string {
+	output, err := remoteOutput(role, script)
+	if err != nil {
+		exitWithSessionError(err)
+	}
+	return output
+}
+
+func journalPath(key string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		ExitWithError(37, "failed to find home directory", err)
+	}
+	hash := sha1.Sum([]byte(key))
+	return filepath.Join(homeDir, TransfersPath, hex.EncodeToString(hash[:])+".json")
+}
+
+func loadJournal(path string) *cpJournal {
+	journal := &cpJournal{Completed: map[int]bool{}}
+	if contents, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(contents, journal)
+	}
+	if journal.Completed == nil {
+		journal.Completed = map[int]bool{}
+	}
+	return journal
+}
+
+func (j *cpJournal) save(path string) {
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if contents, err := json.Marshal(j); err == nil {
+		os.WriteFile(path, contents, 0600)
+	}
+}
+
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func humanizeBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value, unit := float64(size), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+type cpProgress struct {
+	mutex   sync.Mutex
+	name    string
+	total   int64
+	done    int64
+	started time.Time
+}
+
+func (p *cpProgress) add(n int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.done += n
+	p.render()
+}
+
+func (p *cpProgress) render() {
+	const width = 30
+	yellow := color.ToForeground(YellowColor).Decorator()
+	gray := color.ToForeground(LightGrayColor).Decorator()
+	ratio := 1.0
+	if p.total > 0 {
+		ratio = float64(p.done) / float64(p.total)
+	}
+	filled := int(ratio * width)
+	rate := float64(p.done) / max(time.Since(p.started).Seconds(), 0.001)
+	fmt.Fprintf(color.Writer, "\r%s %s %s", p.name, yellow("%s%s", strings.Repeat("█", filled), strings.Repeat("░", width-filled)), gray("%3.0f%% %s/%s %s/s", ratio*100, humanizeBytes(p.done), humanizeBytes(p.total), humanizeBytes(int64(rate))))
+	ansi.ClearRight()
+}
+
+func chunkCount(size int64) int {
+	return int((size + int64(cpChunkSize) - 1) / int64(cpChunkSize))
+}
+
+func transferChunks(file cpFile, journal *cpJournal, journalFile string, progress *cpProgress, transfer func(index int) error) error {
+	mutex := sync.Mutex{}
+	var firstErr error
+	wg := sync.WaitGroup{}
+	semaphore := make(chan struct{}, max(tui.MaxConcurrentRequests, 1))
+	for index := 0; index < chunkCount(file.size); index++ {
+		length := min(int64(cpChunkSize), file.size-int64(index)*int64(cpChunkSize))
+		if journal.Completed[index] {
+			progress.add(length)
+			continue
+		}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, length int64) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			mutex.Lock()
+			failed := firstErr != nil
+			mutex.Unlock()
+			if failed {
+				return
+			}
+			err := transfer(index)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			journal.Completed[index] = true
+			journal.save(journalFile)
+			progress.add(length)
+		}(index, length)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func uploadFile(role *credentials.Role, file cpFile) error {
+	localHash, err := fileSha256(file.local)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(file.local)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	part := file.remote + cpPartSuffix
+	journalFile := journalPath(strings.Join([]string{"upload", role.AccountId, instanceId, file.remote, localHash, strconv.Itoa(int(cpChunkSize))}, "\n"))
+	journal := loadJournal(journalFile)
+	if len(journal.Completed) == 0 {
+		mustRemoteOutput(role, fmt.Sprintf("mkdir -p %s && rm -f %s", shellQuote(path.Dir(file.remote)), shellQuote(part)))
+	}
+	progress := &cpProgress{name: file.remote, total: file.size, started: time.Now()}
+	progress.render()
+	err = transferChunks(file, journal, journalFile, progress, func(index int) error {
+		buffer := make([]byte, cpChunkSize)
+		n, err := source.ReadAt(buffer, int64(index)*int64(cpChunkSize))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		output, err := remoteOutput(role, fmt.Sprintf(
+			"printf '%%s' '%s' | base64 -d | dd of=%s bs=%d seek=%d conv=notrunc status=none && echo KNOX_OK",
+			base64.StdEncoding.EncodeToString(buffer[:n]), shellQuote(part), cpChunkSize, index,
+		))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(output, "KNOX_OK") {
+			return fmt.Errorf("failed to write chunk %d: %s", index, strings.TrimSpace(output))
+		}
+		return nil
+	})
+	fmt.Fprintln(color.Writer)
+	if err != nil {
+		return err
+	}
+	output, err := remoteOutput(role, fmt.Sprintf(
+		`touch %[1]s && truncate -s %[2]d %[1]s && H=$(sha256sum %[1]s | cut -c1-64); echo "KNOX_SHA256: $H"; [ "$H" = %[3]s ] && mv -f %[1]s %[4]s`,
+		shellQuote(part), file.size, localHash, shellQuote(file.remote),
+	))
+	if err != nil {
+		return err
+	}
+	os.Remove(journalFile)
+	if !strings.Contains(output, "KNOX_SHA256: "+localHash) {
+		mustRemoteOutput(role, "rm -f "+shellQuote(part))
+		return fmt.Errorf("checksum mismatch for %s, the transfer will start over next time", file.remote)
+	}
+	return nil
+}
+
+func downloadFile(role *credentials.Role, file cpFile) error {
+	if err := os.MkdirAll(filepath.Dir(file.local), 0755); err != nil {
+		return err
+	}
+	part := file.local + cpPartSuffix
+	journalFile := journalPath(strings.Join([]string{"download", role.AccountId, instanceId, file.remote, strconv.FormatInt(file.size, 10), file.modified, file.local, strconv.Itoa(int(cpChunkSize))}, "\n"))
+	journal := loadJournal(journalFile)
+	flags := os.O_CREATE | os.O_WRONLY
+	if len(journal.Completed) == 0 {
+		flags |= os.O_TRUNC
+	}
+	target, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	progress := &cpProgress{name: file.local, total: file.size, started: time.Now()}
+	progress.render()
+	err = transferChunks(file, journal, journalFile, progress, func(index int) error {
+		output, err := remoteOutput(role, fmt.Sprintf("dd if=%s bs=%d skip=%d count=1 status=none | base64 | tr -d '\\n'", shellQuote(file.remote), cpChunkSize, index))
+		if err != nil {
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(output), ""))
+		if err != nil {
+			return fmt.Errorf("failed to decode chunk %d: %w", index, err)
+		}
+		expected := min(int64(cpChunkSize), file.size-int64(index)*int64(cpChunkSize))
+		if int64(len(data)) != expected {
+			return fmt.Errorf("chunk %d has %d bytes, expected %d", index, len(data), expected)
+		}
+		_, err = target.WriteAt(data, int64(index)*int64(cpChunkSize))
+		return err
+	})
+	fmt.Fprintln(color.Writer)
+	if err != nil {
+		return err
+	}
+	if err = target.Truncate(file.size); err != nil {
+		return err
+	}
+	target.Close()
+	output, err := remoteOutput(role, "sha256sum "+shellQuote(file.remote)+" | cut -c1-64")
+	if err != nil {
+		return err
+	}
+	localHash, err := fileSha256(part)
+	if err != nil {
+		return err
+	}
+	os.Remove(journalFile)
+	if strings.TrimSpace(output) != localHash {
+		os.Remove(part)
+		return fmt.Errorf("checksum mismatch for %s, the transfer will start over next time", file.local)
+	}
+	return os.Rename(part, file.local)
+}
+
+func uploadPlan(role *credentials.Role, source, destination string) []cpFile {
+	info, err := os.Stat(source)
+	if err != nil {
+		ExitWithError(38, "failed to read "+source, err)
+	}
+	if info.IsDir() && !cpRecursive {
+		ExitWithError(39, source+" is a directory, pass --recursive to copy it", nil)
+	}
+	remoteIsDir := strings.HasSuffix(destination, "/") || strings.TrimSpace(mustRemoteOutput(role, fmt.Sprintf("[ -d %s ] && echo yes", shellQuote(destination)))) == "yes"
+	if !info.IsDir() {
+		if remoteIsDir {
+			destination = path.Join(destination, filepath.Base(source))
+		}
+		return []cpFile{{local: source, remote: destination, size: info.Size()}}
+	}
+	if remoteIsDir {
+		destination = path.Join(destination, filepath.Base(filepath.Clean(source)))
+	}
+	files := []cpFile{}
+	filepath.Walk(source, func(local string, info os.FileInfo, err error) error {
+		if err != nil {
+			ExitWithError(38, "failed to read "+local, err)
+		}
+		if info.Mode().IsRegular() {
+			relative, _ := filepath.Rel(source, local)
+			files = append(files, cpFile{local: local, remote: path.Join(destination, filepath.ToSlash(relative)), size: info.Size()})
+		}
+		return nil
+	})
+	return files
+}
+
+// GNU find lists a directory in one pass, BusyBox and other minimal images
+// only have a plain find, so sizes and modification times are looked up per
+// file there, with wc and date when stat is missing as well.
+const DOWNLOAD_PLAN_SCRIPT = `
+size() { stat -c %%s "$1" 2>/dev/null || echo $(($(wc -c < "$1"))); };
+mtime() { stat -c %%Y "$1" 2>/dev/null || date -r "$1" +%%s 2>/dev/null || echo 0; };
+P=%s;
+if [ -d "$P" ]; then
+	echo D;
+	if find "$P" -maxdepth 0 -printf '' > /dev/null 2>&1; then
+		find "$P" -type f -printf '%%s\t%%T@\t%%P\n';
+	else
+		find "$P" -type f | while IFS= read -r F; do R=${F#"$P"}; printf '%%s\t%%s\t%%s\n' "$(size "$F")" "$(mtime "$F")" "${R#/}"; done;
+	fi;
+elif [ -f "$P" ]; then
+	echo F;
+	printf '%%s\t%%s\n' "$(size "$P")" "$(mtime "$P")";
+else
+	echo N;
+fi
+`
+
+func downloadPlanScript(source string) string {
+	return strings.TrimSpace(fmt.Sprintf(DOWNLOAD_PLAN_SCRIPT, shellQuote(source)))
+}
+
+func downloadPlan(role *credentials.Role, source, destination string) []cpFile {
+	output := mustRemoteOutput(role, downloadPlanScript(source))
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	kind := strings.TrimSpace(lines[0])
+	if kind == "N" {
+		ExitWithError(38, source+" does not exist on the instance", nil)
+	}
+	if kind == "D" && !cpRecursive {
+		ExitWithError(39, source+" is a directory, pass --recursive to copy it", nil)
+	}
+	localInfo, err := os.Stat(destination)
+	localIsDir := strings.HasSuffix(destination, string(os.PathSeparator)) || (err == nil && localInfo.IsDir())
+	files := []cpFile{}
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(strings.TrimRight(line, "\r"), "\t", 3)
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || len(fields) < 2 {
+			continue
+		}
+		file := cpFile{remote: source, local: destination, size: size, modified: fields[1]}
+		if kind == "F" {
+			if localIsDir {
+				file.local = filepath.Join(destination, path.Base(source))
+			}
+		} else if len(fields) == 3 {
+			base := destination
+			if localIsDir {
+				base = filepath.Join(destination, path.Base(path.Clean(source)))
+			}
+			file.remote = path.Join(source, fields[2])
+			file.local = filepath.Join(base, filepath.FromSlash(fields[2]))
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+var cpCmd = &cobra.Command{
+	Use:     "cp <source> <destination>",
+	Short:   "Copy files to and from an EC2 instance over SSM sessions",
+	Example: "  knox cp ./dump.sql i-0123456789abcdef0:/tmp/\n  knox cp web-1:/var/log/nginx/access.log ./\n  knox cp -R ./release :/opt/app",
+	Args:    cobra.ExactArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		source, destination := parseCpEndpoint(args[0]), parseCpEndpoint(args[1])
+		if source.remote == destination.remote {
+			return fmt.Errorf("exactly one of source and destination must be remote, in the form [instance]:path")
+		}
+		remote := source
+		if destination.remote {
+			remote = destination
+		}
+		if !strings.HasPrefix(remote.path, "/") {
+			return fmt.Errorf("remote path must be absolute")
+		}
+		if cpChunkSize < 1024 {
+			return fmt.Errorf("chunk size must be at least 1024 bytes")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var role *credentials.Role
+		source, destination := parseCpEndpoint(args[0]), parseCpEndpoint(args[1])
+		remote := source
+		if destination.remote {
+			remote = destination
+		}
+		if remote.target == "" {
+			role, _ = SelectRoleAndInstance("")
+		} else {
+			role = SelectRole()
+			if region == "" {
+				region = workloadRegion(role)
+			}
+			resolveInstance(role, remote.target)
+		}
+		files := []cpFile{}
+		if destination.remote {
+			files = uploadPlan(role, source.path, remote.path)
+		} else {
+			files = downloadPlan(role, remote.path, destination.path)
+		}
+		var total int64
+		for _, file := range files {
+			total += file.size
+		}
+		title := TitleStyle.Decorator()
+		gray := color.ToForeground(LightGrayColor).Decorator()
+		DefaultStyle.Printfln("%s %s", title("Instance ID: "), gray(instanceId))
+		DefaultStyle.Printfln("%s %s", title("Files:       "), gray("%d (%s)", len(files), humanizeBytes(total)))
+		for _, file := range files {
+			var err error
+			if destination.remote {
+				err = uploadFile(role, file)
+			} else {
+				err = downloadFile(role, file)
+			}
+			if err != nil {
+				ExitWithError(40, "failed to copy "+file.local, err)
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cpCmd)
+	cpCmd.Flags().SortFlags = true
+	cpCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
+	cpCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
+	cpCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	cpCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and starting sessions")
+	cpCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	cpCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
+	cpCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+	cpCmd.Flags().BoolVarP(&cpRecursive, "recursive", "R", cpRecursive, "Copy directories recursively")
+	cpCmd.Flags().Uint32Var(&cpChunkSize, "chunk-size", cpChunkSize, "Bytes sent per SSM session, each chunk is retried and resumed on its own")
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/null93/aws-knox/sdk/runner"
+)
+
+// Minimal images have no bash, may have no stat, and have a find without
+// -printf, which the stub stands in for on top of the host's find.
+var downloadPlanPlatforms = []struct {
+	name  string
+	tools []string
+	stubs string
+}{
+	{"gnu", []string{"sh", "base64", "find", "stat", "wc", "date"}, ""},
+	{"busybox", []string{"sh", "base64", "find", "wc", "date"}, `find() { for a; do [ "$a" = -printf ] && return 1; done; command find "$@"; };`},
+}
+
+// The tools are linked into a directory that is the only one on the PATH,
+// so the script can't use anything else.
+func toolsPath(t *testing.T, tools []string) string {
+	bin := t.TempDir()
+	for _, tool := range tools {
+		file, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+		if err := os.Symlink(file, filepath.Join(bin, tool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bin
+}
+
+func TestDownloadPlanScript(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Unix(1700000000, 0)
+	files := map[string]string{"a.txt": "hello", "sub dir/b's.bin": "0123456789", "sub dir/empty": ""}
+	for name, contents := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		source string
+		lines  []string
+	}{
+		{dir, []string{"D", "0\t1700000000\tsub dir/empty", "10\t1700000000\tsub dir/b's.bin", "5\t1700000000\ta.txt"}},
+		{dir + "/", []string{"D", "0\t1700000000\tsub dir/empty", "10\t1700000000\tsub dir/b's.bin", "5\t1700000000\ta.txt"}},
+		{filepath.Join(dir, "sub dir", "b's.bin"), []string{"F", "10\t1700000000"}},
+		{filepath.Join(dir, "missing"), []string{"N"}},
+	}
+	for _, platform := range downloadPlanPlatforms {
+		bin := toolsPath(t, platform.tools)
+		for _, test := range tests {
+			command := exec.Command(filepath.Join(bin, "sh"), "-c", runner.Script(cpShell, remoteOutputScript(platform.stubs+downloadPlanScript(test.source))))
+			command.Env = []string{"PATH=" + bin}
+			result, err := command.Output()
+			if err != nil {
+				t.Fatalf("%s %q: %v", platform.name, test.source, err)
+			}
+			output, err := parseRemoteOutput(result)
+			if err != nil {
+				t.Fatalf("%s %q: %v", platform.name, test.source, err)
+			}
+			lines := strings.Split(strings.TrimSpace(output), "\n")
+			for i, line := range lines {
+				// GNU find reports fractional seconds.
+				fields := strings.Split(line, "\t")
+				if len(fields) > 1 {
+					fields[1], _, _ = strings.Cut(fields[1], ".")
+				}
+				lines[i] = strings.Join(fields, "\t")
+			}
+			sort.Strings(lines[1:])
+			if strings.Join(lines, "\n") != strings.Join(test.lines, "\n") {
+				t.Errorf("%s %q: output is %q, want %q", platform.name, test.source, lines, test.lines)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/null93/aws-knox/sdk/credentials"
+)
+
+const (
+	DefaultSessionDocument = "AWS-StartInteractiveCommand"
+)
+
+var (
+	connectUser          string
+	connectUserRules     []connectUserRule
+	sessionDocumentRules []sessionDocumentRule
+)
+
+type instanceRule struct {
+	Account string `mapstructure:"account"`
+	Filter  string `mapstructure:"filter"`
+}
+
+type connectUserRule struct {
+	instanceRule `mapstructure:",squash"`
+	User         string `mapstructure:"user"`
+}
+
+// Parameters are "name=value" strings because viper lowercases map keys and
+// SSM parameter names are case sensitive. Values can use {{user}} for the OS
+// user, {{uid}} for --uid, and command parameters {{command}} for the quoted
+// command after "--".
+type sessionDocumentRule struct {
+	instanceRule      `mapstructure:",squash"`
+	Document          string   `mapstructure:"document"`
+	Parameters        []string `mapstructure:"parameters"`
+	CommandParameters []string `mapstructure:"command_parameters"`
+}
+
+var defaultSessionDocumentRule = sessionDocumentRule{
+	Document:          DefaultSessionDocument,
+	Parameters:        []string{"command=sudo su - {{user}}"},
+	CommandParameters: []string{"command=sudo su - {{user}} -c {{command}}"},
+}
+
+// Tags are only known for instances loaded by the picker or looked up, so a
+// rule with a filter never matches an unknown instance.
+func (r instanceRule) matches(role *credentials.Role, instance *credentials.Instance) bool {
+	if r.Account != "" && fmt.Sprintf("%012s", r.Account) != role.AccountId {
+		return false
+	}
+	if r.Filter != "" {
+		filter, err := credentials.ParseInstanceFilter(r.Filter)
+		if err != nil {
+			ExitWithError(58, "failed to parse rule filter "+r.Filter, err)
+		}
+		if instance == nil || !filter.Matches(instance) {
+			return false
+		}
+	}
+	return true
+}
+
+func needsInstanceTags() bool {
+	for _, rule := range sessionDocumentRules {
+		if rule.Filter != "" {
+			return true
+		}
+	}
+	for _, rule := range connectUserRules {
+		if rule.Filter != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// An empty user means the session switches to the user with --uid, which is
+// resolved on the instance. Explicit flags win over configured rules.
+func connectUserFor(uidChanged bool, role *credentials.Role, instance *credentials.Instance) string {
+	if connectUser != "" || uidChanged {
+		return connectUser
+	}
+	for _, rule := range connectUserRules {
+		if rule.matches(role, instance) {
+			return rule.User
+		}
+	}
+	return ""
+}
+
+func connectUserLabel(user string, uid uint32) string {
+	if user == "" {
+		return fmt.Sprintf("uid %d", uid)
+	}
+	return user
+}
+
+func sessionDocumentFor(role *credentials.Role, instance *credentials.Instance) sessionDocumentRule {
+	for _, rule := range sessionDocumentRules {
+		if rule.matches(role, instance) {
+			return rule
+		}
+	}
+	return defaultSessionDocumentRule
+}
+
+func (r sessionDocumentRule) name() string {
+	if r.Document == "" {
+		return "default"
+	}
+	return r.Document
+}
+
+func (r sessionDocumentRule) render(user string, uid uint32, command string) (map[string][]string, error) {
+	templates := r.Parameters
+	if command != "" {
+		if len(r.CommandParameters) < 1 {
+			return nil, fmt.Errorf("document %s has no command_parameters to run a command with", r.name())
+		}
+		templates = r.CommandParameters
+	}
+	userExpression := fmt.Sprintf("`id -un %d`", uid)
+	if user != "" {
+		userExpression = shellQuote(user)
+	}
+	replacer := strings.NewReplacer("{{user}}", userExpression, "{{uid}}", fmt.Sprintf("%d", uid), "{{command}}", shellQuote(command))
+	parameters := map[string][]string{}
+	for _, template := range templates {
+		name, value, found := strings.Cut(template, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid parameter %q, expected name=value", template)
+		}
+		name = strings.TrimSpace(name)
+		parameters[name] = append(parameters[name], replacer.Replace(value))
+	}
+	return parameters, nil
+}
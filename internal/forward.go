@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/null93/aws-knox/pkg/ansi"
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/runner"
+	. "github.com/null93/aws-knox/sdk/style"
+	"github.com/spf13/cobra"
+)
+
+var (
+	forwardSpecs       []string
+	forwardBindAddress = "127.0.0.1"
+)
+
+type forward struct {
+	bindAddress string
+	localPort   uint16
+	remoteHost  string
+	remotePort  uint16
+	pluginPort  uint16
+	status      string
+	connections int
+}
+
+func parsePort(value string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return uint16(port), nil
+}
+
+// Fields are separated by colons like in ssh -L, and IPv6 addresses are put
+// in brackets so their colons are not taken as separators.
+func splitForward(spec string) ([]string, error) {
+	fields := []string{}
+	rest := spec
+	for {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 || (end+1 < len(rest) && rest[end+1] != ':') {
+				return nil, fmt.Errorf("invalid forward %q, addresses in brackets must be followed by :", spec)
+			}
+			fields = append(fields, rest[1:end])
+			if end+1 >= len(rest) {
+				return fields, nil
+			}
+			rest = rest[end+2:]
+			continue
+		}
+		field, after, found := strings.Cut(rest, ":")
+		fields = append(fields, field)
+		if !found {
+			return fields, nil
+		}
+		rest = after
+	}
+}
+
+func parseForward(spec string) (*forward, error) {
+	f := &forward{bindAddress: forwardBindAddress, status: "starting"}
+	parts, err := splitForward(spec)
+	if err != nil {
+		return nil, err
+	}
+	local, remote := "", ""
+	switch len(parts) {
+	case 2:
+		local, remote = parts[0], parts[1]
+	case 3:
+		if _, err := strconv.Atoi(parts[0]); err == nil {
+			local, f.remoteHost, remote = parts[0], parts[1], parts[2]
+		} else {
+			f.bindAddress, local, remote = parts[0], parts[1], parts[2]
+		}
+	case 4:
+		f.bindAddress, local, f.remoteHost, remote = parts[0], parts[1], parts[2], parts[3]
+	default:
+		return nil, fmt.Errorf("invalid forward %q, must be [bind_address:]local_port:[remote_host:]remote_port", spec)
+	}
+	if f.localPort, err = parsePort(local); err != nil {
+		return nil, err
+	}
+	if f.remotePort, err = parsePort(remote); err != nil {
+		return nil, err
+	}
+	if f.remotePort == 0 {
+		return nil, fmt.Errorf("invalid forward %q, remote port cannot be 0", spec)
+	}
+	return f, nil
+}
+
+func isPluginBindAddress(address string) bool {
+	return address == "" || address == "localhost" || address == "127.0.0.1"
+}
+
+func freePort(address string) (uint16, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+func (f *forward) local() string {
+	return net.JoinHostPort(f.bindAddress, strconv.Itoa(int(f.localPort)))
+}
+
+func (f *forward) remote() string {
+	if f.remoteHost == "" {
+		return net.JoinHostPort(instanceId, strconv.Itoa(int(f.remotePort)))
+	}
+	return net.JoinHostPort(f.remoteHost, strconv.Itoa(int(f.remotePort))) + " via " + instanceId
+}
+
+func (f *forward) relay() error {
+	listener, err := net.Listen("tcp", f.local())
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				upstream, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(f.pluginPort))))
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}(conn)
+		}
+	}()
+	return nil
+}
+
+func renderForwards(forwards []*forward, rerender bool) {
+	if rerender {
+		ansi.MoveCursorUp(len(forwards))
+	}
+	ansi.ClearDown()
+	yellow := color.ToForeground(YellowColor).Decorator()
+	gray := color.ToForeground(LightGrayColor).Decorator()
+	title := TitleStyle.Decorator()
+	localWidth, remoteWidth := 0, 0
+	for _, f := range forwards {
+		localWidth = max(localWidth, len(f.local()))
+		remoteWidth = max(remoteWidth, len(f.remote()))
+	}
+	for _, f := range forwards {
+		status := f.status
+		if f.connections == 1 {
+			status = "1 connection accepted"
+		} else if f.connections > 1 {
+			status = fmt.Sprintf("%d connections accepted", f.connections)
+		}
+		DefaultStyle.Printfln(
+			"%s %s %s",
+			title("%-*s", localWidth, f.local()),
+			gray("→ %-*s", remoteWidth, f.remote()),
+			yellow(status),
+		)
+	}
+}
+
+var forwardCmd = &cobra.Command{
+	Use:     "forward [instance-search-term]",
+	Short:   "Forward local ports to an EC2 instance or to remote hosts through it",
+	Example: "  knox forward -L 8080:80\n  knox forward -L 0:db.internal:5432 -L 0.0.0.0:6379:cache.internal:6379",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(forwardSpecs) < 1 {
+			return fmt.Errorf("at least one --forward must be specified")
+		}
+		for _, spec := range forwardSpecs {
+			if _, err := parseForward(spec); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		searchTerm := strings.Join(args, " ")
+		forwards := []*forward{}
+		for _, spec := range forwardSpecs {
+			f, _ := parseForward(spec)
+			forwards = append(forwards, f)
+		}
+		role, _ := SelectRoleAndInstance(searchTerm)
+
+		for _, f := range forwards {
+			if f.localPort == 0 {
+				if f.localPort, err = freePort(f.bindAddress); err != nil {
+					ExitWithError(24, "failed to find a free local port", err)
+				}
+			}
+			f.pluginPort = f.localPort
+			if !isPluginBindAddress(f.bindAddress) {
+				if f.pluginPort, err = freePort("127.0.0.1"); err != nil {
+					ExitWithError(24, "failed to find a free local port", err)
+				}
+				if err = f.relay(); err != nil {
+					ExitWithError(25, "failed to listen on "+f.local(), err)
+				}
+			}
+		}
+
+		gray := color.ToForeground(LightGrayColor).Decorator()
+		title := TitleStyle.Decorator()
+		DefaultStyle.Printfln("")
+		DefaultStyle.Printfln("%s %s", title("SSO Session: "), gray(role.SessionName))
+		DefaultStyle.Printfln("%s %s", title("Region:      "), gray(region))
+		DefaultStyle.Printfln("%s %s", title("Account ID:  "), gray(role.AccountId))
+		DefaultStyle.Printfln("%s %s", title("Role Name:   "), gray(role.Name))
+		DefaultStyle.Printfln("%s %s", title("Instance ID: "), gray(instanceId))
+		DefaultStyle.Printfln("")
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		var firstErr error
+		mutex := sync.Mutex{}
+		wg := sync.WaitGroup{}
+		renderForwards(forwards, false)
+		for _, f := range forwards {
+			wg.Add(1)
+			go func(f *forward) {
+				defer wg.Done()
+				request := sessionRequest(role, instanceId, runner.ModePort, func() (*ssm.StartSessionOutput, error) {
+					if f.remoteHost == "" {
+						return role.StartPortForward(region, instanceId, f.remotePort, f.pluginPort)
+					}
+					return role.StartPortForwardToRemoteHost(region, instanceId, f.remoteHost, f.remotePort, f.pluginPort)
+				})
+				request.LocalPort = f.pluginPort
+				request.OnEvent = func(event runner.Event) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					switch event {
+					case runner.EventWaiting:
+						f.status = "waiting for connections"
+					case runner.EventAccepted:
+						f.connections++
+					}
+					renderForwards(forwards, true)
+				}
+				_, err := runSession(ctx, request)
+				mutex.Lock()
+				defer mutex.Unlock()
+				f.connections = 0
+				f.status = "closed"
+				if err != nil {
+					f.status = "failed: " + err.Error()
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+				renderForwards(forwards, true)
+			}(f)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			exitWithSessionError(firstErr)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(forwardCmd)
+	forwardCmd.Flags().SortFlags = true
+	forwardCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
+	forwardCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
+	forwardCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	forwardCmd.Flags().StringVarP(&instanceId, "instance-id", "i", instanceId, "EC2 instance ID")
+	forwardCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and starting sessions")
+	forwardCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	forwardCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
+	forwardCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+	forwardCmd.Flags().StringArrayVarP(&forwardSpecs, "forward", "L", forwardSpecs, "Forward in the form [bind_address:]local_port:[remote_host:]remote_port, IPv6 addresses in brackets, local port 0 picks a free port (repeatable)")
+	forwardCmd.Flags().StringVar(&forwardBindAddress, "bind", forwardBindAddress, "Default local bind address")
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec        string
+		bindAddress string
+		localPort   uint16
+		remoteHost  string
+		remotePort  uint16
+		err         bool
+	}{
+		{spec: "8080:80", bindAddress: "127.0.0.1", localPort: 8080, remotePort: 80},
+		{spec: "0:80", bindAddress: "127.0.0.1", localPort: 0, remotePort: 80},
+		{spec: "5432:db.internal:5432", bindAddress: "127.0.0.1", localPort: 5432, remoteHost: "db.internal", remotePort: 5432},
+		{spec: "0.0.0.0:8080:80", bindAddress: "0.0.0.0", localPort: 8080, remotePort: 80},
+		{spec: "localhost:8080:10.0.0.5:80", bindAddress: "localhost", localPort: 8080, remoteHost: "10.0.0.5", remotePort: 80},
+		{spec: "[::1]:8080:80", bindAddress: "::1", localPort: 8080, remotePort: 80},
+		{spec: "[::]:8080:[fd00::5]:80", bindAddress: "::", localPort: 8080, remoteHost: "fd00::5", remotePort: 80},
+		{spec: "8080:[2001:db8::1]:443", bindAddress: "127.0.0.1", localPort: 8080, remoteHost: "2001:db8::1", remotePort: 443},
+		{spec: ":8080:80", bindAddress: "", localPort: 8080, remotePort: 80},
+		{spec: "80", err: true},
+		{spec: "8080:0", err: true},
+		{spec: "8080:http", err: true},
+		{spec: "70000:80", err: true},
+		{spec: "a:b:c:d:e", err: true},
+		{spec: "::1:8080:80", err: true},
+		{spec: "[::1:8080:80", err: true},
+		{spec: "[::1]8080:80", err: true},
+		{spec: "[::1]", err: true},
+	}
+	for _, test := range tests {
+		f, err := parseForward(test.spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseForward(%q) is %+v, want an error", test.spec, f)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseForward(%q): %v", test.spec, err)
+			continue
+		}
+		if f.bindAddress != test.bindAddress || f.localPort != test.localPort || f.remoteHost != test.remoteHost || f.remotePort != test.remotePort {
+			t.Errorf("parseForward(%q) is %s:%d:%s:%d, want %s:%d:%s:%d", test.spec, f.bindAddress, f.localPort, f.remoteHost, f.remotePort, test.bindAddress, test.localPort, test.remoteHost, test.remotePort)
+		}
+	}
+}
+
+func TestForwardLocal(t *testing.T) {
+	f, err := parseForward("[::1]:8080:[fd00::5]:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.local() != "[::1]:8080" {
+		t.Errorf("local address is %s", f.local())
+	}
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"github.com/null93/aws-knox/sdk/credentials"
+	"github.com/null93/aws-knox/sdk/tui"
+)
+
+func GetLastUsedRoleCredentials() *credentials.Role {
+	var err error
+	var sessions credentials.Sessions
+	var session *credentials.Session
+	var role credentials.Role
+	if role, err = credentials.GetLastUsedRole(); err != nil {
+		ExitWithError(1, "failed to get last used role", err)
+	}
+	if role.Credentials == nil || role.Credentials.IsExpired() {
+		if sessions, err = credentials.GetSessions(); err != nil {
+			ExitWithError(2, "failed to parse sso sessions", err)
+		}
+		if session = sessions.FindByName(role.SessionName); session == nil {
+			ExitWithError(3, "failed to find sso session "+role.SessionName, err)
+		}
+		if session.ClientToken == nil || session.ClientToken.IsExpired() {
+			if err = tui.ClientLogin(session); err != nil {
+				ExitWithError(4, "failed to authorize device login", err)
+			}
+		}
+		if err = session.RefreshRoleCredentials(&role); err != nil {
+			ExitWithError(5, "failed to get credentials", err)
+		}
+		if err = role.Credentials.Save(session.Name, role.CacheKey()); err != nil {
+			ExitWithError(6, "failed to save credentials", err)
+		}
+	}
+	return &role
+}
+
+func SelectRole() *credentials.Role {
+	var role *credentials.Role
+	var action string
+	if lastUsed {
+		return GetLastUsedRoleCredentials()
+	}
+	for role == nil {
+		if !selectCachedFirst || (sessionName != "" && accountId != "" && roleName != "") {
+			action, role = SelectRoleCredentialsStartingFromSession()
+		} else {
+			action, role = SelectRoleCredentialsStartingFromCache()
+		}
+		if action == "toggle-view" {
+			toggleView()
+		} else if action == "back" {
+			goBack(&role)
+		} else if action == "delete" || action == "refresh" {
+			role = nil
+		}
+	}
+	return role
+}
+
+func SelectRoleAndInstance(searchTerm string) (*credentials.Role, *credentials.Instance) {
+	role, instances := selectRoleAndInstances(searchTerm, false)
+	if len(instances) < 1 {
+		return role, nil
+	}
+	return role, &instances[0]
+}
+
+// Instances are empty when the instance was passed with --instance-id.
+func SelectRoleAndInstances(searchTerm string) (*credentials.Role, credentials.Instances) {
+	return selectRoleAndInstances(searchTerm, true)
+}
+
+func selectRoleAndInstances(searchTerm string, multiSelect bool) (*credentials.Role, credentials.Instances) {
+	currentSelector := "instance"
+	var err error
+	var role *credentials.Role
+	var instances credentials.Instances
+	var action string
+	if lastUsed {
+		role = GetLastUsedRoleCredentials()
+	}
+	for {
+		if role == nil {
+			if !selectCachedFirst || (sessionName != "" && accountId != "" && roleName != "") {
+				action, role = SelectRoleCredentialsStartingFromSession()
+			} else {
+				action, role = SelectRoleCredentialsStartingFromCache()
+			}
+			if action == "toggle-view" {
+				toggleView()
+				continue
+			}
+			if action == "back" {
+				goBack(&role)
+				continue
+			}
+			if action == "delete" || action == "refresh" {
+				role = nil
+				continue
+			}
+		}
+		if region == "" {
+			region = workloadRegion(role)
+		}
+		if instanceId == "" {
+			if currentSelector == "instance" {
+				if multiSelect {
+					instances, action, err = tui.SelectInstances(role, instanceRegions(role), instanceFiltersFor(role), searchTerm, instanceColTags)
+				} else {
+					var instance *credentials.Instance
+					if instance, action, err = tui.SelectInstance(role, instanceRegions(role), instanceFiltersFor(role), searchTerm, instanceColTags); instance != nil {
+						instances = credentials.Instances{*instance}
+					}
+				}
+				if err != nil {
+					ExitWithError(19, "failed to pick an instance", err)
+				} else if action == "back" {
+					goBack(&role)
+					continue
+				} else if action == "pick-region" {
+					currentSelector = "region"
+					continue
+				} else if action == "toggle-regions" {
+					allRegions = !allRegions
+					continue
+				}
+				instanceId = instances[0].Id
+				region = instances[0].Region
+			} else {
+				pickedRegion := ""
+				if pickedRegion, action, err = tui.SelectRegion(role, region, instanceFiltersFor(role)); err != nil {
+					ExitWithError(20, "failed to pick a region", err)
+				} else if action == "back" {
+					currentSelector = "instance"
+					continue
+				}
+				currentSelector = "instance"
+				region = pickedRegion
+				allRegions = false
+				continue
+			}
+		}
+		return role, instances
+	}
+}
+
+// Targets are instance IDs in the current region or instance filters, and
+// every instance is returned once even if several targets match it.
+func resolveTargets(role *credentials.Role, targets []string) credentials.Instances {
+	instances := credentials.Instances{}
+	expressions := []string{}
+	for _, target := range targets {
+		if isInstanceId(target) {
+			instances = append(instances, credentials.Instance{Id: target, Region: region})
+		} else {
+			expressions = append(expressions, target)
+		}
+	}
+	if len(expressions) > 0 {
+		filters, err := credentials.ParseInstanceFilters(expressions)
+		if err != nil {
+			ExitWithError(23, "failed to parse instance filters", err)
+		}
+		instances = append(instances, loadInstances(role, instanceRegions(role), append(instanceFiltersFor(role), filters...))...)
+	}
+	seen := map[string]bool{}
+	unique := credentials.Instances{}
+	for _, instance := range instances {
+		if !seen[instance.Id] {
+			seen[instance.Id] = true
+			unique = append(unique, instance)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/credentials"
+	"github.com/null93/aws-knox/sdk/recording"
+	. "github.com/null93/aws-knox/sdk/style"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	RecordingsPath = ".aws/knox/recordings"
+)
+
+var (
+	recordSession       bool
+	recordAccounts      []string
+	recordingsSpeed     float64 = 1
+	recordingsIdleLimit         = 2 * time.Second
+)
+
+func recordingsDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		ExitWithError(53, "failed to find home directory", err)
+	}
+	return filepath.Join(homeDir, RecordingsPath)
+}
+
+// Accounts listed in record_accounts are always recorded, "*" matches all.
+func isRecordingEnforced(role *credentials.Role) bool {
+	return slices.Contains(recordAccounts, "*") || slices.Contains(recordAccounts, role.AccountId)
+}
+
+func startRecording(role *credentials.Role) *recording.Recorder {
+	width, height, err := term.GetSize(int(os.Stdin.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	recorder, err := recording.New(recordingsDir(), recording.Metadata{
+		SessionName: role.SessionName,
+		AccountId:   role.AccountId,
+		RoleName:    role.Name,
+		InstanceId:  instanceId,
+		Region:      region,
+	}, width, height)
+	if err != nil {
+		ExitWithError(54, "failed to start recording", err)
+	}
+	return recorder
+}
+
+func findRecording(name string) recording.Metadata {
+	recordings, err := recording.List(recordingsDir())
+	if err != nil {
+		ExitWithError(55, "failed to list recordings", err)
+	}
+	name = strings.TrimSuffix(filepath.Base(name), recording.CastExtension)
+	for _, r := range recordings {
+		if r.Name == name {
+			return r
+		}
+	}
+	ExitWithError(56, "recording "+name+" not found", nil)
+	return recording.Metadata{}
+}
+
+var recordingsCmd = &cobra.Command{
+	Use:   "recordings",
+	Short: "List recorded sessions",
+	Run: func(cmd *cobra.Command, args []string) {
+		recordings, err := recording.List(recordingsDir())
+		if err != nil {
+			ExitWithError(55, "failed to list recordings", err)
+		}
+		if len(recordings) < 1 {
+			DefaultStyle.Printfln("No recordings found in %s", recordingsDir())
+			return
+		}
+		gray := color.ToForeground(LightGrayColor).Decorator()
+		yellow := color.ToForeground(YellowColor).Decorator()
+		nameWidth, roleWidth, regionWidth := len("Name"), len("Role Name"), len("Region")
+		for _, r := range recordings {
+			nameWidth = max(nameWidth, len(r.Name))
+			roleWidth = max(roleWidth, len(r.RoleName))
+			regionWidth = max(regionWidth, len(r.Region))
+		}
+		HeaderStyle.Printfln("%-*s  %-12s  %-*s  %-*s  %-19s  %s", nameWidth, "Name", "Account ID", roleWidth, "Role Name", regionWidth, "Region", "Started", "Duration")
+		for _, r := range recordings {
+			duration := "in progress"
+			if !r.End.IsZero() {
+				duration = r.Duration().Round(time.Second).String()
+			}
+			DefaultStyle.Printfln(
+				"%s  %s  %s  %s  %s  %s",
+				yellow("%-*s", nameWidth, r.Name),
+				gray("%-12s", r.AccountId),
+				gray("%-*s", roleWidth, r.RoleName),
+				gray("%-*s", regionWidth, r.Region),
+				gray(r.Start.Local().Format("2006-01-02 15:04:05")),
+				gray(duration),
+			)
+		}
+	},
+}
+
+var recordingsPlayCmd = &cobra.Command{
+	Use:   "play <name>",
+	Short: "Replay a recorded session in the terminal",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		r := findRecording(args[0])
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := recording.Play(ctx, r.CastPath(recordingsDir()), os.Stdout, recordingsSpeed, recordingsIdleLimit)
+		fmt.Fprintln(os.Stdout)
+		if err != nil && ctx.Err() == nil {
+			ExitWithError(57, "failed to replay recording", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(recordingsCmd)
+	recordingsCmd.AddCommand(recordingsPlayCmd)
+	recordingsPlayCmd.Flags().SortFlags = true
+	recordingsPlayCmd.Flags().Float64Var(&recordingsSpeed, "speed", recordingsSpeed, "Playback speed multiplier")
+	recordingsPlayCmd.Flags().DurationVar(&recordingsIdleLimit, "idle-limit", recordingsIdleLimit, "Shorten pauses longer than this, 0 keeps them")
+}
@@ -2,7 +2,9 @@ package internal
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"syscall"
 
 	"github.com/null93/aws-knox/pkg/ansi"
@@ -19,13 +21,21 @@ var (
 	selectCachedFirst bool   = false
 	connectUid        uint32 = 0
 	lastUsed          bool   = false
+	allRegions        bool   = false
 	doNotCache        bool   = false
 	sessionName       string
 	accountId         string
 	roleName          string
 	instanceId        string
 	region            string
+	defaultRegion     string
 	accountAliases    map[string]string
+	accountRegions    map[string]string
+	sessionRegions    map[string]string
+	searchRegions     []string
+	instanceFilters   []string
+	accountFilters    map[string][]string
+	filterFlags       []string
 	instanceColTags   []string
 	format            = "json"
 )
@@ -74,13 +84,32 @@ func goBack(role **credentials.Role) {
 	}
 }
 
+var exitHooks []func()
+
+func onExit(hook func()) {
+	exitHooks = append(exitHooks, hook)
+}
+
+func runExitHooks() {
+	hooks := exitHooks
+	exitHooks = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// Errors go to stdout like the rest of the output, commands that own stdout
+// like ssh-proxy send them to stderr instead.
+var errorWriter io.Writer = os.Stdout
+
 func ExitWithError(code int, message string, err error) {
-	fmt.Printf("Error: %s\n", message)
+	fmt.Fprintf(errorWriter, "Error: %s\n", message)
 	if err != nil && debug {
-		fmt.Printf("Debug: %s\n", err.Error())
+		fmt.Fprintf(errorWriter, "Debug: %s\n", err.Error())
 	} else {
-		fmt.Printf("Info:  run with --debug flag for more info\n")
+		fmt.Fprintf(errorWriter, "Info:  run with --debug flag for more info\n")
 	}
+	runExitHooks()
 	os.Exit(code)
 }
 
@@ -148,41 +177,101 @@ func SelectRoleCredentialsStartingFromSession() (string, *credentials.Role) {
 func SelectRoleCredentialsStartingFromCache() (string, *credentials.Role) {
 	var err error
 	var action string
-	var sessions credentials.Sessions
-	var session *credentials.Session
-	var role *credentials.Role
-	if role, action, err = tui.SelectRolesCredentials(accountAliases); err != nil {
+	var roles credentials.Roles
+	if roles, action, err = tui.SelectRolesCredentials(accountAliases); err != nil {
 		ExitWithError(12, "failed to cached role credentials", err)
 	} else if action != "" {
-		return action, role
+		return action, nil
 	}
-	if role.Credentials == nil || role.Credentials.IsExpired() {
-		if sessions, err = credentials.GetSessions(); err != nil {
-			ExitWithError(13, "failed to parse sso sessions", err)
-		}
-		if session = sessions.FindByName(role.SessionName); session == nil {
-			ExitWithError(14, "failed to find sso session "+role.SessionName, err)
-		}
-		if session.ClientToken == nil || session.ClientToken.IsExpired() {
-			if err = tui.ClientLogin(session); err != nil {
-				ExitWithError(15, "failed to authorize device login", err)
-			}
-		}
-		if err = session.RefreshRoleCredentials(role); err != nil {
-			ExitWithError(16, "failed to get credentials", err)
-		}
-		if !doNotCache {
-			if err = role.Credentials.Save(session.Name, role.CacheKey()); err != nil {
-				ExitWithError(17, "failed to save credentials", err)
-			}
+	// Several roles are refreshed and cached together, then the picker is
+	// shown again so one of them can be picked.
+	if len(roles) > 1 {
+		for i := range roles {
+			refreshCachedRoleCredentials(&roles[i])
 		}
+		return "refresh", nil
 	}
+	role := &roles[0]
+	refreshCachedRoleCredentials(role)
 	if err = role.MarkLastUsed(); err != nil {
 		ExitWithError(18, "failed to mark last used role", err)
 	}
 	return "", role
 }
 
+func refreshCachedRoleCredentials(role *credentials.Role) {
+	var err error
+	var sessions credentials.Sessions
+	var session *credentials.Session
+	if role.Credentials != nil && !role.Credentials.IsExpired() {
+		return
+	}
+	if sessions, err = credentials.GetSessions(); err != nil {
+		ExitWithError(13, "failed to parse sso sessions", err)
+	}
+	if session = sessions.FindByName(role.SessionName); session == nil {
+		ExitWithError(14, "failed to find sso session "+role.SessionName, err)
+	}
+	if session.ClientToken == nil || session.ClientToken.IsExpired() {
+		if err = tui.ClientLogin(session); err != nil {
+			ExitWithError(15, "failed to authorize device login", err)
+		}
+	}
+	if err = session.RefreshRoleCredentials(role); err != nil {
+		ExitWithError(16, "failed to get credentials", err)
+	}
+	if !doNotCache {
+		if err = role.Credentials.Save(session.Name, role.CacheKey()); err != nil {
+			ExitWithError(17, "failed to save credentials", err)
+		}
+	}
+}
+
+func workloadRegion(role *credentials.Role) string {
+	if region != "" {
+		return region
+	}
+	if value, ok := accountRegions[role.AccountId]; ok && value != "" {
+		return value
+	}
+	if value, ok := sessionRegions[strings.ToLower(role.SessionName)]; ok && value != "" {
+		return value
+	}
+	if defaultRegion != "" {
+		return defaultRegion
+	}
+	return role.Region
+}
+
+func instanceRegions(role *credentials.Role) []string {
+	if !allRegions {
+		return []string{region}
+	}
+	if len(searchRegions) > 0 {
+		return searchRegions
+	}
+	regions, err := role.GetRegions(region)
+	if err != nil || len(regions.Enabled()) < 1 {
+		return []string{region}
+	}
+	names := []string{}
+	for _, enabled := range regions.Enabled() {
+		names = append(names, enabled.Name)
+	}
+	return names
+}
+
+func instanceFiltersFor(role *credentials.Role) credentials.InstanceFilters {
+	expressions := append([]string{}, instanceFilters...)
+	expressions = append(expressions, accountFilters[role.AccountId]...)
+	expressions = append(expressions, filterFlags...)
+	filters, err := credentials.ParseInstanceFilters(expressions)
+	if err != nil {
+		ExitWithError(23, "failed to parse instance filters", err)
+	}
+	return filters
+}
+
 func padAccountNumbers(aliases map[string]string) map[string]string {
 	result := map[string]string{}
 	for account, alias := range aliases {
@@ -191,6 +280,17 @@ func padAccountNumbers(aliases map[string]string) map[string]string {
 	return result
 }
 
+func savePickerSort(title string, sort string) {
+	sorts := viper.GetStringMapString("picker_sorts")
+	if sort == "" {
+		delete(sorts, title)
+	} else {
+		sorts[title] = sort
+	}
+	viper.Set("picker_sorts", sorts)
+	viper.WriteConfig()
+}
+
 func setupConfigFile() {
 	if homeDir, err := os.UserHomeDir(); err == nil {
 		os.MkdirAll(homeDir+"/.aws/knox", os.FileMode(0700))
@@ -203,15 +303,69 @@ func setupConfigFile() {
 	viper.SetDefault("select_cached_first", false)
 	viper.SetDefault("filter_strategy", "fuzzy")
 	viper.SetDefault("max_items_to_show", 10)
+	viper.SetDefault("max_concurrent_requests", 8)
 	viper.SetDefault("account_aliases", map[string]string{})
+	viper.SetDefault("default_region", "")
+	viper.SetDefault("account_regions", map[string]string{})
+	viper.SetDefault("session_regions", map[string]string{})
+	viper.SetDefault("search_all_regions", false)
+	viper.SetDefault("search_regions", []string{})
+	viper.SetDefault("hide_offline_instances", false)
+	viper.SetDefault("show_preview", false)
+	viper.SetDefault("mouse_support", false)
+	viper.SetDefault("instance_cache_ttl", "5m")
+	viper.SetDefault("session_backend", SessionBackendAuto)
+	viper.SetDefault("default_ssh_user", "ec2-user")
+	viper.SetDefault("ssh_users", []sshUserRule{})
+	viper.SetDefault("record_accounts", []string{})
+	viper.SetDefault("session_documents", []sessionDocumentRule{})
+	viper.SetDefault("connect_users", []connectUserRule{})
+	viper.SetDefault("instance_filters", []string{})
+	viper.SetDefault("account_instance_filters", map[string][]string{})
 	viper.SetDefault("instance_col_tags", []string{"Instance Type", "Private IP", "Public IP", "Name"})
+	viper.SetDefault("picker_sorts", map[string]string{})
 	viper.SafeWriteConfig()
 	viper.ReadInConfig()
 	tui.MaxItemsToShow = viper.GetInt("max_items_to_show")
 	tui.FilterStrategy = viper.GetString("filter_strategy")
+	tui.MaxConcurrentRequests = viper.GetInt("max_concurrent_requests")
+	tui.HideOfflineInstances = viper.GetBool("hide_offline_instances")
+	tui.ShowPreview = viper.GetBool("show_preview")
+	tui.MouseSupport = viper.GetBool("mouse_support")
+	tui.InstanceCacheTTL = viper.GetDuration("instance_cache_ttl")
+	tui.PickerSorts = viper.GetStringMapString("picker_sorts")
+	tui.SavePickerSort = savePickerSort
 	selectCachedFirst = viper.GetBool("select_cached_first")
 	connectUid = viper.GetUint32("default_connect_uid")
+	sessionBackendName = viper.GetString("session_backend")
+	defaultSSHUser = viper.GetString("default_ssh_user")
+	if err := viper.UnmarshalKey("ssh_users", &sshUserRules); err != nil {
+		ExitWithError(29, "failed to parse ssh_users", err)
+	}
+	if err := viper.UnmarshalKey("connect_users", &connectUserRules); err != nil {
+		ExitWithError(60, "failed to parse connect_users", err)
+	}
+	if err := viper.UnmarshalKey("session_documents", &sessionDocumentRules); err != nil {
+		ExitWithError(58, "failed to parse session_documents", err)
+	}
+	recordAccounts = []string{}
+	for _, account := range viper.GetStringSlice("record_accounts") {
+		if account != "*" {
+			account = fmt.Sprintf("%012s", account)
+		}
+		recordAccounts = append(recordAccounts, account)
+	}
 	accountAliases = padAccountNumbers(viper.GetStringMapString("account_aliases"))
+	defaultRegion = viper.GetString("default_region")
+	accountRegions = padAccountNumbers(viper.GetStringMapString("account_regions"))
+	sessionRegions = viper.GetStringMapString("session_regions")
+	allRegions = viper.GetBool("search_all_regions")
+	searchRegions = viper.GetStringSlice("search_regions")
+	instanceFilters = viper.GetStringSlice("instance_filters")
+	accountFilters = map[string][]string{}
+	for account, filters := range viper.GetStringMapStringSlice("account_instance_filters") {
+		accountFilters[fmt.Sprintf("%012s", account)] = filters
+	}
 	instanceColTags = viper.GetStringSlice("instance_col_tags")
 
 }
@@ -0,0 +1,324 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/null93/aws-knox/pkg/ansi"
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/credentials"
+	. "github.com/null93/aws-knox/sdk/style"
+	"github.com/null93/aws-knox/sdk/tui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runTargets      []string
+	runOutput       = "table"
+	runOutputDir    string
+	runTimeout      uint32 = 600
+	runComment      string
+	runPollInterval = 2 * time.Second
+	runPollBackoff  = 30 * time.Second
+	runPollRetries  = 8
+)
+
+type runTarget struct {
+	instance   credentials.Instance
+	invocation *credentials.CommandInvocation
+	err        error
+}
+
+type runResult struct {
+	InstanceId string `json:"instanceId"`
+	Name       string `json:"name"`
+	Region     string `json:"region"`
+	CommandId  string `json:"commandId"`
+	Status     string `json:"status"`
+	ExitCode   int32  `json:"exitCode"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (t *runTarget) status() string {
+	if t.err != nil {
+		return "Error"
+	}
+	if t.invocation == nil {
+		return "Sending"
+	}
+	return t.invocation.Status
+}
+
+func (t *runTarget) isDone() bool {
+	return t.err != nil || (t.invocation != nil && t.invocation.IsDone())
+}
+
+func (t *runTarget) isSuccess() bool {
+	return t.err == nil && t.invocation != nil && t.invocation.IsSuccess()
+}
+
+func (t *runTarget) result() runResult {
+	result := runResult{InstanceId: t.instance.Id, Name: t.instance.Tags["Name"], Region: t.instance.Region, Status: t.status(), ExitCode: -1}
+	if t.invocation != nil {
+		result.CommandId = t.invocation.CommandId
+		result.ExitCode = t.invocation.ExitCode
+		result.Stdout = t.invocation.Stdout
+		result.Stderr = t.invocation.Stderr
+	}
+	if t.err != nil {
+		result.Error = t.err.Error()
+	}
+	return result
+}
+
+func resolveRunTargets(role *credentials.Role) []*runTarget {
+	targets := []*runTarget{}
+	for _, instance := range resolveTargets(role, runTargets) {
+		targets = append(targets, &runTarget{instance: instance})
+	}
+	return targets
+}
+
+func renderRunTargets(targets []*runTarget, rerender bool) {
+	if rerender {
+		ansi.MoveCursorUp(len(targets) + 1)
+	}
+	ansi.ClearDown()
+	gray := color.ToForeground(LightGrayColor).Decorator()
+	yellow := color.ToForeground(YellowColor).Decorator()
+	green := color.ToForeground(GreenColor).Decorator()
+	red := color.ToForeground(RedColor).Decorator()
+	idWidth, nameWidth, regionWidth := len("Instance ID"), len("Name"), len("Region")
+	for _, t := range targets {
+		idWidth = max(idWidth, len(t.instance.Id))
+		nameWidth = max(nameWidth, len(t.instance.Tags["Name"]))
+		regionWidth = max(regionWidth, len(t.instance.Region))
+	}
+	HeaderStyle.Printfln("%-*s  %-*s  %-*s  %-11s  %4s  %s", idWidth, "Instance ID", nameWidth, "Name", regionWidth, "Region", "Status", "Exit", "Duration")
+	for _, t := range targets {
+		status := yellow("%-11s", t.status())
+		if t.isSuccess() {
+			status = green("%-11s", t.status())
+		} else if t.isDone() {
+			status = red("%-11s", t.status())
+		}
+		exitCode, duration := "", ""
+		if t.invocation != nil && t.invocation.IsDone() {
+			exitCode = fmt.Sprintf("%d", t.invocation.ExitCode)
+		}
+		if t.invocation != nil && !t.invocation.StartedAt.IsZero() && !t.invocation.FinishedAt.IsZero() {
+			duration = t.invocation.FinishedAt.Sub(t.invocation.StartedAt).Round(time.Millisecond).String()
+		}
+		DefaultStyle.Printfln(
+			"%-*s  %s  %s  %s  %4s  %s",
+			idWidth, t.instance.Id,
+			gray("%-*s", nameWidth, t.instance.Tags["Name"]),
+			gray("%-*s", regionWidth, t.instance.Region),
+			status,
+			exitCode,
+			gray(duration),
+		)
+	}
+}
+
+func printRunOutputs(targets []*runTarget) {
+	title := TitleStyle.Decorator()
+	gray := color.ToForeground(LightGrayColor).Decorator()
+	red := color.ToForeground(RedColor).Decorator()
+	for _, t := range targets {
+		result := t.result()
+		DefaultStyle.Printfln("")
+		DefaultStyle.Printfln("%s %s", title("── %s", t.instance.Id), gray("%s %s", result.Name, result.Status))
+		if result.Error != "" {
+			DefaultStyle.Printfln("%s", red(result.Error))
+		}
+		if result.Stdout != "" {
+			fmt.Fprint(color.Writer, strings.TrimRight(result.Stdout, "\n")+"\n")
+		}
+		if result.Stderr != "" {
+			DefaultStyle.Printfln("%s", red(strings.TrimRight(result.Stderr, "\n")))
+		}
+	}
+}
+
+func writeRunOutputs(targets []*runTarget) {
+	if err := os.MkdirAll(runOutputDir, 0755); err != nil {
+		ExitWithError(35, "failed to create output directory", err)
+	}
+	for _, t := range targets {
+		result := t.result()
+		for extension, contents := range map[string]string{"stdout": result.Stdout, "stderr": result.Stderr} {
+			path := filepath.Join(runOutputDir, fmt.Sprintf("%s.%s", t.instance.Id, extension))
+			if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+				ExitWithError(35, "failed to write "+path, err)
+			}
+		}
+	}
+}
+
+// Throttling and other transient errors are retried with a growing, jittered
+// delay, so one failed poll does not mark a running command as failed.
+func pollRunTarget(role *credentials.Role, t *runTarget, semaphore chan struct{}, onUpdate func(func())) {
+	commandId := t.invocation.CommandId
+	delay, retries := runPollInterval, 0
+	for done := false; !done; {
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay/4)+1)))
+		semaphore <- struct{}{}
+		invocation, err := role.GetCommandInvocation(t.instance.Region, commandId, t.instance.Id)
+		<-semaphore
+		if err != nil && credentials.IsTransientError(err) && retries < runPollRetries {
+			delay, retries = min(delay*2, runPollBackoff), retries+1
+			continue
+		}
+		delay, retries = runPollInterval, 0
+		onUpdate(func() {
+			if err != nil {
+				t.err = err
+			} else {
+				t.invocation = invocation
+			}
+			done = t.isDone()
+		})
+	}
+}
+
+var runCmd = &cobra.Command{
+	Use:     "run [instance-search-term] -- <command>",
+	Short:   "Run a shell command on one or more EC2 instances with SSM Run Command",
+	Example: "  knox run -- uptime\n  knox run --targets tag:Role=web --targets i-0123456789abcdef0 -- systemctl status nginx\n  knox run --targets tag:Env=prod --output json -- cat /etc/os-release",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if dash := cmd.ArgsLenAtDash(); dash < 0 || dash == len(args) {
+			return fmt.Errorf("a command must be passed after --")
+		}
+		if runOutput != "table" && runOutput != "json" {
+			return fmt.Errorf("invalid output %q, must be table or json", runOutput)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var role *credentials.Role
+		dash := cmd.ArgsLenAtDash()
+		searchTerm := strings.Join(args[:dash], " ")
+		command := strings.Join(args[dash:], " ")
+		if runOutput == "json" {
+			renderToStderr()
+		}
+
+		targets := []*runTarget{}
+		if len(runTargets) == 0 {
+			var instances credentials.Instances
+			if role, instances = SelectRoleAndInstances(searchTerm); len(instances) < 1 {
+				instances = credentials.Instances{{Id: instanceId, Region: region}}
+			}
+			for _, instance := range instances {
+				targets = append(targets, &runTarget{instance: instance})
+			}
+		} else {
+			role = SelectRole()
+			if region == "" {
+				region = workloadRegion(role)
+			}
+			targets = resolveRunTargets(role)
+		}
+		if len(targets) < 1 {
+			ExitWithError(34, "no instances matched the passed targets", nil)
+		}
+
+		mutex := sync.Mutex{}
+		onUpdate := func(update func()) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			update()
+			renderRunTargets(targets, true)
+		}
+		DefaultStyle.Printfln("")
+		renderRunTargets(targets, false)
+
+		byRegion := map[string][]*runTarget{}
+		for _, t := range targets {
+			byRegion[t.instance.Region] = append(byRegion[t.instance.Region], t)
+		}
+		for targetRegion, regionTargets := range byRegion {
+			for start := 0; start < len(regionTargets); start += credentials.MaxSendCommandTargets {
+				batch := regionTargets[start:min(start+credentials.MaxSendCommandTargets, len(regionTargets))]
+				instanceIds := []string{}
+				for _, t := range batch {
+					instanceIds = append(instanceIds, t.instance.Id)
+				}
+				commandId, err := role.SendShellCommand(targetRegion, instanceIds, []string{command}, int32(runTimeout), runComment)
+				onUpdate(func() {
+					for _, t := range batch {
+						if err != nil {
+							t.err = err
+						} else {
+							t.invocation = &credentials.CommandInvocation{CommandId: commandId, InstanceId: t.instance.Id, Region: targetRegion, Status: credentials.CommandStatusPending, ExitCode: -1}
+						}
+					}
+				})
+			}
+		}
+
+		wg := sync.WaitGroup{}
+		semaphore := make(chan struct{}, max(tui.MaxConcurrentRequests, 1))
+		for _, t := range targets {
+			if t.err != nil {
+				continue
+			}
+			wg.Add(1)
+			go func(t *runTarget) {
+				defer wg.Done()
+				pollRunTarget(role, t, semaphore, onUpdate)
+			}(t)
+		}
+		wg.Wait()
+
+		if runOutputDir != "" {
+			writeRunOutputs(targets)
+		}
+		if runOutput == "json" {
+			results := []runResult{}
+			for _, t := range targets {
+				results = append(results, t.result())
+			}
+			encoded, _ := json.MarshalIndent(results, "", "  ")
+			fmt.Println(string(encoded))
+		} else if runOutputDir == "" {
+			printRunOutputs(targets)
+		}
+		failed := 0
+		for _, t := range targets {
+			if !t.isSuccess() {
+				failed++
+			}
+		}
+		if failed > 0 {
+			ExitWithError(36, fmt.Sprintf("command failed on %d of %d instances", failed, len(targets)), nil)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+	runCmd.Flags().SortFlags = true
+	runCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
+	runCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
+	runCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	runCmd.Flags().StringVarP(&instanceId, "instance-id", "i", instanceId, "EC2 instance ID")
+	runCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and sending commands")
+	runCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	runCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
+	runCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+	runCmd.Flags().StringArrayVarP(&runTargets, "targets", "t", runTargets, "Instance ID or filter in the form name=value[,value] to run on, skips the picker (repeatable)")
+	runCmd.Flags().StringVarP(&runOutput, "output", "o", runOutput, "Output format, table or json")
+	runCmd.Flags().StringVar(&runOutputDir, "output-dir", runOutputDir, "Write <instance-id>.stdout and <instance-id>.stderr files to this directory")
+	runCmd.Flags().Uint32Var(&runTimeout, "timeout", runTimeout, "Delivery and execution timeout in seconds")
+	runCmd.Flags().StringVar(&runComment, "comment", runComment, "Comment recorded with the command")
+}
@@ -66,11 +66,8 @@ var selectCmd = &cobra.Command{
 					goBack(&role)
 					continue
 				}
-				if action == "delete" {
-					if role != nil && role.Credentials != nil {
-						role.Credentials.DeleteCache(role.SessionName, role.CacheKey())
-						role = nil
-					}
+				if action == "delete" || action == "refresh" {
+					role = nil
 					continue
 				}
 			}
@@ -82,6 +79,8 @@ var selectCmd = &cobra.Command{
 				fmt.Printf("export AWS_ACCESS_KEY_ID=%q\n", targetRole.Credentials.AccessKeyId)
 				fmt.Printf("export AWS_SECRET_ACCESS_KEY=%q\n", targetRole.Credentials.SecretAccessKey)
 				fmt.Printf("export AWS_SESSION_TOKEN=%q\n", targetRole.Credentials.SessionToken)
+				fmt.Printf("export AWS_REGION=%q\n", workloadRegion(targetRole))
+				fmt.Printf("export AWS_DEFAULT_REGION=%q\n", workloadRegion(targetRole))
 				break
 			} else {
 				if json, err := targetRole.Credentials.ToJSON(); err != nil {
@@ -102,6 +101,7 @@ func init() {
 	selectCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
 	selectCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
 	selectCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	selectCmd.Flags().StringVar(&region, "region", region, "Region to export with env format")
 	selectCmd.Flags().BoolVarP(&doNotCache, "no-cache", "n", doNotCache, "Do not cache credentials")
 	selectCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "Use last used role credentials")
 }
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/null93/aws-knox/sdk/credentials"
+	"github.com/null93/aws-knox/sdk/runner"
+)
+
+const (
+	SessionBackendAuto   = "auto"
+	SessionBackendNative = "native"
+	SessionBackendPlugin = "plugin"
+)
+
+var (
+	sessionBackendName = SessionBackendAuto
+)
+
+func newSessionRunner() runner.Runner {
+	switch sessionBackendName {
+	case SessionBackendPlugin:
+		return &runner.Plugin{}
+	case SessionBackendNative:
+		return &runner.Native{}
+	default:
+		return &runner.Native{Fallback: &runner.Plugin{}}
+	}
+}
+
+// Requests terminate their session through the role that started it, so an
+// aborted command does not linger on the instance until it times out.
+func sessionRequest(role *credentials.Role, target string, mode runner.Mode, start runner.Starter) runner.Request {
+	sessionRegion := region
+	return runner.Request{
+		Mode:   mode,
+		Region: sessionRegion,
+		Target: target,
+		Start:  start,
+		Terminate: func(ctx context.Context, sessionId string) error {
+			return role.TerminateSession(ctx, sessionRegion, sessionId)
+		},
+	}
+}
+
+func runSession(ctx context.Context, request runner.Request) (*runner.Result, error) {
+	return newSessionRunner().Run(ctx, request)
+}
+
+func runScript(role *credentials.Role, target, shell, script string) (*runner.Result, error) {
+	request := sessionRequest(role, target, runner.ModeCommand, func() (*ssm.StartSessionOutput, error) {
+		return role.StartCommand(region, target, runner.Script(shell, script))
+	})
+	request.Capture = true
+	return runSession(context.Background(), request)
+}
+
+func exitWithSessionError(err error) {
+	if errors.Is(err, runner.ErrStartSession) {
+		ExitWithError(20, "failed to start ssm session", err)
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		ExitWithError(21, "failed to find session-manager-plugin, see "+SESSION_MANAGER_PLUGIN_URL, err)
+	}
+	ExitWithError(22, "failed to run ssm session", err)
+}
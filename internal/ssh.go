@@ -0,0 +1,337 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/null93/aws-knox/pkg/ansi"
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/credentials"
+	"github.com/null93/aws-knox/sdk/runner"
+	. "github.com/null93/aws-knox/sdk/style"
+	"github.com/null93/aws-knox/sdk/tui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sshHostPrefix   string
+	sshUser         string
+	sshIdentityFile string
+	sshPort         uint16 = 22
+	defaultSSHUser         = "ec2-user"
+	sshUserRules    []sshUserRule
+	unsafeHostChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+type sshUserRule struct {
+	instanceRule `mapstructure:",squash"`
+	User         string `mapstructure:"user"`
+}
+
+// ssh owns stdin and stdout of a ProxyCommand, so anything knox draws has to
+// go to stderr, which is still attached to the terminal.
+func renderToStderr() {
+	ansi.Writer = os.Stderr
+	color.Writer = os.Stderr
+	errorWriter = os.Stderr
+}
+
+func isInstanceId(value string) bool {
+	return strings.HasPrefix(value, "i-") || strings.HasPrefix(value, "mi-")
+}
+
+func shellQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func sshHostAlias(instance credentials.Instance) string {
+	name := unsafeHostChars.ReplaceAllString(strings.TrimSpace(instance.Tags["Name"]), "-")
+	if name == "" {
+		name = instance.Id
+	}
+	return sshHostPrefix + name
+}
+
+func loadInstances(role *credentials.Role, regions []string, filters credentials.InstanceFilters) credentials.Instances {
+	instances := credentials.Instances{}
+	for _, instanceRegion := range regions {
+		cached, fetchedAt, err := role.GetCachedInstances(instanceRegion, filters)
+		if err == nil && cached != nil && time.Since(fetchedAt) < tui.InstanceCacheTTL {
+			instances = append(instances, cached...)
+			continue
+		}
+		fetched, err := role.GetManagedInstances(instanceRegion, filters)
+		if errors.Is(err, credentials.ErrInstanceInformation) {
+			if debug {
+				fmt.Fprintf(errorWriter, "Debug: %s\n", err.Error())
+			}
+			instances = append(instances, fetched...)
+			continue
+		}
+		if err != nil {
+			ExitWithError(26, "failed to get instances in "+instanceRegion, err)
+		}
+		role.SaveCachedInstances(instanceRegion, filters, fetched)
+		instances = append(instances, fetched...)
+	}
+	return instances
+}
+
+func resolveInstance(role *credentials.Role, target string) {
+	if isInstanceId(target) {
+		instanceId = target
+		return
+	}
+	matches := credentials.Instances{}
+	for _, instance := range loadInstances(role, instanceRegions(role), instanceFiltersFor(role)) {
+		if instance.Tags["Name"] == target || sshHostAlias(instance) == target {
+			matches = append(matches, instance)
+		}
+	}
+	if len(matches) < 1 {
+		ExitWithError(27, "no instance found named "+target, nil)
+	}
+	if len(matches) > 1 {
+		ExitWithError(28, fmt.Sprintf("%d instances are named %s, use an instance id instead", len(matches), target), nil)
+	}
+	instanceId = matches[0].Id
+	region = matches[0].Region
+}
+
+func sshUserFor(role *credentials.Role, instance *credentials.Instance) string {
+	if sshUser != "" {
+		return sshUser
+	}
+	for _, rule := range sshUserRules {
+		if rule.matches(role, instance) {
+			return rule.User
+		}
+	}
+	return defaultSSHUser
+}
+
+// Instance Connect keys are only accepted for 60 seconds, so unless one is
+// passed a throwaway key pair is generated for every connection.
+func sshKeyPair() (string, string, func()) {
+	if sshIdentityFile != "" {
+		publicKey, err := os.ReadFile(sshIdentityFile + ".pub")
+		if err != nil {
+			ExitWithError(30, "failed to read public key "+sshIdentityFile+".pub", err)
+		}
+		return sshIdentityFile, string(publicKey), func() {}
+	}
+	directory, err := os.MkdirTemp("", "knox-ssh-")
+	if err != nil {
+		ExitWithError(30, "failed to create temporary key directory", err)
+	}
+	cleanup := func() { os.RemoveAll(directory) }
+	privateKeyPath := filepath.Join(directory, "id_ed25519")
+	if output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "knox", "-f", privateKeyPath).CombinedOutput(); err != nil {
+		cleanup()
+		ExitWithError(30, "failed to generate ssh key pair: "+strings.TrimSpace(string(output)), err)
+	}
+	publicKey, err := os.ReadFile(privateKeyPath + ".pub")
+	if err != nil {
+		cleanup()
+		ExitWithError(30, "failed to read generated public key", err)
+	}
+	return privateKeyPath, string(publicKey), cleanup
+}
+
+// Child commands get the full role so they read its cached credentials
+// instead of prompting, args are expected to be shell quoted already.
+func knoxCommand(role *credentials.Role, instanceRegion, subcommand string, args ...string) string {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = "knox"
+	}
+	return strings.Join(append([]string{
+		shellQuote(executable), subcommand,
+		"--sso-session", shellQuote(role.SessionName),
+		"--account-id", role.AccountId,
+		"--role-name", shellQuote(role.Name),
+		"--region", instanceRegion,
+	}, args...), " ")
+}
+
+func sshProxyCommand(role *credentials.Role, instanceRegion string) string {
+	return knoxCommand(role, instanceRegion, "ssh-proxy", "%h", "%p")
+}
+
+var sshCmd = &cobra.Command{
+	Use:     "ssh [instance-search-term] [-- ssh-args...]",
+	Short:   "SSH to an EC2 instance over SSM using an EC2 Instance Connect key",
+	Example: "  knox ssh web\n  knox ssh --user ubuntu web -- -L 8080:localhost:80",
+	Run: func(cmd *cobra.Command, args []string) {
+		sshArgs := []string{}
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			args, sshArgs = args[:dash], args[dash:]
+		}
+		searchTerm := strings.Join(args, " ")
+		role, instance := SelectRoleAndInstance(searchTerm)
+		if instance == nil {
+			instance = loadInstances(role, []string{region}, credentials.InstanceFilters{}).FindById(instanceId)
+		}
+		if strings.HasPrefix(instanceId, "mi-") {
+			ExitWithError(31, "ec2 instance connect does not support hybrid managed nodes", nil)
+		}
+		user := sshUserFor(role, instance)
+
+		yellow := color.ToForeground(YellowColor).Decorator()
+		gray := color.ToForeground(LightGrayColor).Decorator()
+		title := TitleStyle.Decorator()
+		DefaultStyle.Printfln("")
+		DefaultStyle.Printfln("%s %s", title("SSO Session: "), gray(role.SessionName))
+		DefaultStyle.Printfln("%s %s", title("Region:      "), gray(region))
+		DefaultStyle.Printfln("%s %s", title("Account ID:  "), gray(role.AccountId))
+		DefaultStyle.Printfln("%s %s", title("Role Name:   "), gray(role.Name))
+		DefaultStyle.Printfln("%s %s", title("Instance ID: "), yellow(instanceId))
+		DefaultStyle.Printfln("%s %s", title("OS User:     "), gray(user))
+		DefaultStyle.Printfln("")
+
+		privateKeyPath, publicKey, cleanup := sshKeyPair()
+		defer cleanup()
+		if err := role.SendSSHPublicKey(region, instanceId, user, publicKey); err != nil {
+			cleanup()
+			ExitWithError(32, "failed to send ssh public key with ec2 instance connect", err)
+		}
+		command := exec.Command("ssh", append([]string{
+			"-i", privateKeyPath,
+			"-o", "IdentitiesOnly=yes",
+			"-o", "ProxyCommand=" + sshProxyCommand(role, region),
+			"-p", strconv.Itoa(int(sshPort)),
+			user + "@" + instanceId,
+		}, sshArgs...)...)
+		command.Stdin = os.Stdin
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		if err := command.Run(); err != nil {
+			cleanup()
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			ExitWithError(33, "failed to run ssh", err)
+		}
+	},
+}
+
+var sshProxyCmd = &cobra.Command{
+	Use:     "ssh-proxy <instance-id-or-name> <port>",
+	Short:   "Pipe stdio to an SSH port on an EC2 instance, for use as an ssh ProxyCommand",
+	Example: "  ssh -o ProxyCommand='knox ssh-proxy -l %h %p' ec2-user@i-0123456789abcdef0",
+	Args:    cobra.ExactArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !lastUsed && (sessionName == "" || accountId == "" || roleName == "") {
+			return fmt.Errorf("ssh-proxy cannot prompt, pass --last-used or --sso-session, --account-id, and --role-name")
+		}
+		_, err := parsePort(args[1])
+		return err
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var role *credentials.Role
+		renderToStderr()
+		port, _ := parsePort(args[1])
+		if lastUsed {
+			role = GetLastUsedRoleCredentials()
+		} else {
+			_, role = SelectRoleCredentialsStartingFromSession()
+		}
+		if region == "" {
+			region = workloadRegion(role)
+		}
+		resolveInstance(role, args[0])
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+		defer stop()
+		_, err := runSession(ctx, sessionRequest(role, instanceId, runner.ModePipe, func() (*ssm.StartSessionOutput, error) {
+			return role.StartSSHSession(region, instanceId, port)
+		}))
+		if err != nil {
+			exitWithSessionError(err)
+		}
+	},
+}
+
+var sshConfigCmd = &cobra.Command{
+	Use:     "ssh-config",
+	Short:   "Print ssh_config Host blocks that connect to EC2 instances over SSM",
+	Example: "  knox ssh-config --prefix prod- > ~/.ssh/knox_config",
+	Run: func(cmd *cobra.Command, args []string) {
+		renderToStderr()
+		role := SelectRole()
+		if region == "" {
+			region = workloadRegion(role)
+		}
+		instances := loadInstances(role, instanceRegions(role), instanceFiltersFor(role))
+		sort.SliceStable(instances, func(i, j int) bool {
+			return sshHostAlias(instances[i]) < sshHostAlias(instances[j])
+		})
+		counts := map[string]int{}
+		for _, instance := range instances {
+			counts[sshHostAlias(instance)]++
+		}
+		fmt.Printf("# Generated by knox for %s (%s) as %s\n", role.AccountId, role.SessionName, role.Name)
+		for _, instance := range instances {
+			alias := sshHostAlias(instance)
+			if counts[alias] > 1 {
+				alias += "-" + instance.Id
+			}
+			fmt.Println()
+			fmt.Printf("Host %s\n", alias)
+			fmt.Printf("  HostName %s\n", instance.Id)
+			fmt.Printf("  User %s\n", sshUserFor(role, &instance))
+			fmt.Printf("  ProxyCommand %s\n", sshProxyCommand(role, instance.Region))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sshCmd)
+	sshCmd.Flags().SortFlags = true
+	sshCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
+	sshCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
+	sshCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	sshCmd.Flags().StringVarP(&instanceId, "instance-id", "i", instanceId, "EC2 instance ID")
+	sshCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and starting sessions")
+	sshCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	sshCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
+	sshCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+	sshCmd.Flags().StringVarP(&sshUser, "user", "u", sshUser, "OS user to push the key for and log in as")
+	sshCmd.Flags().StringVar(&sshIdentityFile, "identity-file", sshIdentityFile, "Private key to use instead of a generated one, its .pub file is pushed")
+	sshCmd.Flags().Uint16VarP(&sshPort, "port", "p", sshPort, "SSH port on the instance")
+
+	RootCmd.AddCommand(sshProxyCmd)
+	sshProxyCmd.Flags().SortFlags = true
+	sshProxyCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
+	sshProxyCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
+	sshProxyCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	sshProxyCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and starting sessions")
+	sshProxyCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	sshProxyCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
+	sshProxyCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+
+	RootCmd.AddCommand(sshConfigCmd)
+	sshConfigCmd.Flags().SortFlags = true
+	sshConfigCmd.Flags().StringVarP(&sessionName, "sso-session", "s", sessionName, "SSO session name")
+	sshConfigCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
+	sshConfigCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
+	sshConfigCmd.Flags().StringVar(&region, "region", region, "Region for querying instances")
+	sshConfigCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	sshConfigCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
+	sshConfigCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+	sshConfigCmd.Flags().StringVar(&sshHostPrefix, "prefix", sshHostPrefix, "Prefix for generated Host names")
+}
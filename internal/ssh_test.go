@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/null93/aws-knox/sdk/credentials"
+)
+
+func TestSSHUserFor(t *testing.T) {
+	rules, user := sshUserRules, sshUser
+	t.Cleanup(func() { sshUserRules, sshUser = rules, user })
+	sshUserRules = []sshUserRule{
+		{instanceRule{Filter: "tag:OS=ubuntu*"}, "ubuntu"},
+		{instanceRule{Account: "123456789012", Filter: "platform=Debian*"}, "admin"},
+		{instanceRule{Account: "123456789012"}, "centos"},
+	}
+	ubuntu := &credentials.Instance{Id: "i-1", Tags: map[string]string{"OS": "ubuntu-22.04"}}
+	debian := &credentials.Instance{Id: "i-2", PlatformName: "Debian 12", Tags: map[string]string{}}
+	tests := []struct {
+		account  string
+		instance *credentials.Instance
+		flag     string
+		user     string
+	}{
+		{"123456789012", ubuntu, "", "ubuntu"},
+		{"210987654321", ubuntu, "", "ubuntu"},
+		{"123456789012", debian, "", "admin"},
+		{"210987654321", debian, "", defaultSSHUser},
+		{"123456789012", nil, "", "centos"},
+		{"210987654321", nil, "", defaultSSHUser},
+		{"123456789012", ubuntu, "root", "root"},
+	}
+	for _, test := range tests {
+		sshUser = test.flag
+		role := &credentials.Role{AccountId: test.account}
+		if user := sshUserFor(role, test.instance); user != test.user {
+			t.Errorf("sshUserFor(%s, %+v) is %s, want %s", test.account, test.instance, user, test.user)
+		}
+	}
+}
@@ -1,283 +1,247 @@
 package internal
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
-	"os/exec"
+	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/null93/aws-knox/pkg/color"
 	"github.com/null93/aws-knox/sdk/credentials"
+	"github.com/null93/aws-knox/sdk/runner"
 	. "github.com/null93/aws-knox/sdk/style"
-	"github.com/null93/aws-knox/sdk/tui"
 	"github.com/spf13/cobra"
 )
 
 const RSYNC_CONFIG = `
-uid = 0
-gid = 0
+uid = %s
+gid = %s
 use chroot = yes
-read only = false
+read only = %t
+write only = %t
 hosts allow = 127.0.0.1
+auth users = knox
+secrets file = /tmp/knox-rsyncd.secrets
+strict modes = yes
 
 [sync]
-path = /root/knox-sync
+path = %s
 comment = knox sync
 `
 
 const RSYNC_INIT_SCRIPT = `
-if ! command -v rsync > /dev/null; then echo "EXIT_CODE: 45"; exit 45; fi;
-if ! [ -d /root/knox-sync ]; then mkdir -p /root/knox-sync; fi;
-if [ -f /run/knox-rsyncd.pid ]; then echo "EXIT_CODE: 46"; exit 46; fi;
+if ! command -v rsync > /dev/null; then exit 45; fi;
+if [ -f /run/knox-rsyncd.pid ]; then exit 46; fi;
+if ! id -u %[1]s > /dev/null 2>&1; then exit 48; fi;
+KNOX_PATH=%[2]s;
+case "$KNOX_PATH" in "~/"*) KNOX_PATH="$(getent passwd %[1]s | cut -d: -f6)/${KNOX_PATH#??}";; esac;
+mkdir -p "$KNOX_PATH" && chown %[1]s: "$KNOX_PATH" || exit 49;
+echo "KNOX_PATH: $KNOX_PATH";
+echo "KNOX_GROUP: $(id -gn %[1]s)";
 `
 
+const RSYNC_START_SCRIPT = `
+echo %s | base64 -d > /tmp/knox-rsyncd.conf;
+echo %s | base64 -d > /tmp/knox-rsyncd.secrets;
+chmod 600 /tmp/knox-rsyncd.conf /tmp/knox-rsyncd.secrets;
+rsync --daemon --port=%d --log-file=/dev/null --config=/tmp/knox-rsyncd.conf --dparam=pidfile=/run/knox-rsyncd.pid || exit 47;
+`
+
+const RSYNC_CLEAN_SCRIPT = `
+rm -f /tmp/knox-rsyncd.conf /tmp/knox-rsyncd.secrets;
+if [ -f /run/knox-rsyncd.pid ]; then kill -9 $(cat /run/knox-rsyncd.pid); rm -f /run/knox-rsyncd.pid; fi;
+`
+
+const (
+	SyncModeBoth = "both"
+	SyncModePush = "push"
+	SyncModePull = "pull"
+)
+
 var (
-	rsyncPort uint16 = 9999
-	localPort uint16 = 8080
+	rsyncPort      uint16 = 9999
+	localPort      uint16 = 8080
+	syncRemotePath        = "/root/knox-sync"
+	syncUser              = "root"
+	syncMode              = SyncModeBoth
+	syncOutputLine        = regexp.MustCompile(`(?m)(KNOX_PATH|KNOX_GROUP): (.+?)\r?$`)
 )
 
-func rsyncInit(role *credentials.Role, instanceId string) {
-	var binaryPath string
-	details, err := role.StartCommand(instanceId, strings.ReplaceAll(RSYNC_INIT_SCRIPT, "\n", " "))
-	if err != nil {
-		ExitWithError(20, "failed to start ssm session", err)
+// The path is quoted as a whole, so a leading "~/" reaches the script as is
+// and resolves to the home of the sync user rather than the one running it.
+func rsyncInitScript(user, remotePath string) string {
+	return fmt.Sprintf(RSYNC_INIT_SCRIPT, shellQuote(user), shellQuote(remotePath))
+}
+
+func rsyncSecret() string {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		ExitWithError(50, "failed to generate rsync secret", err)
 	}
-	if binaryPath, err = exec.LookPath("session-manager-plugin"); err != nil {
-		ExitWithError(21, "failed to find session-manager-plugin, see "+SESSION_MANAGER_PLUGIN_URL, err)
+	return hex.EncodeToString(secret)
+}
+
+func rsyncInit(role *credentials.Role, instanceId string) (string, string) {
+	script := rsyncInitScript(syncUser, syncRemotePath)
+	result, err := runScript(role, instanceId, "bash", script)
+	if err != nil {
+		exitWithSessionError(err)
 	}
-	command := exec.Command(
-		binaryPath,
-		fmt.Sprintf(`{"SessionId": "%s", "TokenValue": "%s", "StreamUrl": "%s"}`, *details.SessionId, *details.TokenValue, *details.StreamUrl),
-		region,
-		"StartSession",
-		"", // No Profile
-		fmt.Sprintf(`{"Target": "%s"}`, instanceId),
-		fmt.Sprintf("https://ssm.%s.amazonaws.com", region),
-	)
-	command.Stdin = os.Stdin
-	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true}
-	if output, err := command.CombinedOutput(); err != nil {
-		ExitWithError(22, "failed to run session-manager-plugin", err)
-	} else if strings.Contains(string(output), "EXIT_CODE: 45") {
+	switch result.ExitCode {
+	case 45:
 		ExitWithError(45, "rsync is not installed on the target instance", nil)
-	} else if strings.Contains(string(output), "EXIT_CODE: 46") {
-		fmt.Println("OUTPUT", string(output))
+	case 46:
 		ExitWithError(46, "rsyncd is already running on the target instance", nil)
+	case 48:
+		ExitWithError(48, "user "+syncUser+" does not exist on the target instance", nil)
+	case 49:
+		ExitWithError(49, "failed to create "+syncRemotePath+" on the target instance", nil)
+	}
+	values := map[string]string{}
+	for _, match := range syncOutputLine.FindAllStringSubmatch(string(result.Output), -1) {
+		values[match[1]] = match[2]
+	}
+	if values["KNOX_PATH"] == "" || values["KNOX_GROUP"] == "" {
+		ExitWithError(49, "failed to resolve remote path and group on the target instance", nil)
 	}
 	if debug {
 		fmt.Println("Debug: rsync detected on the target instance")
-		fmt.Println("Debug: ensuring /root/knox-sync folder exists")
+		fmt.Printf("Debug: ensuring %s folder exists and is owned by %s\n", values["KNOX_PATH"], syncUser)
 		fmt.Println("Debug: making sure another rsync daemon is not running")
 	}
+	return values["KNOX_PATH"], values["KNOX_GROUP"]
 }
 
-func rsyncStart(role *credentials.Role, instanceId string) {
-	var binaryPath string
-	var configEncoded = base64.StdEncoding.EncodeToString([]byte(RSYNC_CONFIG))
-	var startCommand = fmt.Sprintf("rsync --daemon --port=%d --log-file=/dev/null --config=/tmp/knox-rsyncd.conf --dparam=pidfile=/run/knox-rsyncd.pid", rsyncPort)
-	details, err := role.StartCommand(instanceId, fmt.Sprintf("echo %s | base64 -d > /tmp/knox-rsyncd.conf; %s || (echo 'EXIT_CODE: 47' || exit 47)", configEncoded, startCommand))
-	if err != nil {
-		ExitWithError(20, "failed to start ssm session", err)
-	}
-	if binaryPath, err = exec.LookPath("session-manager-plugin"); err != nil {
-		ExitWithError(21, "failed to find session-manager-plugin, see "+SESSION_MANAGER_PLUGIN_URL, err)
-	}
-	command := exec.Command(
-		binaryPath,
-		fmt.Sprintf(`{"SessionId": "%s", "TokenValue": "%s", "StreamUrl": "%s"}`, *details.SessionId, *details.TokenValue, *details.StreamUrl),
-		region,
-		"StartSession",
-		"", // No Profile
-		fmt.Sprintf(`{"Target": "%s"}`, instanceId),
-		fmt.Sprintf("https://ssm.%s.amazonaws.com", region),
+func rsyncStart(role *credentials.Role, instanceId, remotePath, group, secret string) {
+	config := fmt.Sprintf(RSYNC_CONFIG, syncUser, group, syncMode == SyncModePull, syncMode == SyncModePush, remotePath)
+	secrets := fmt.Sprintf("knox:%s\n", secret)
+	script := fmt.Sprintf(
+		RSYNC_START_SCRIPT,
+		base64.StdEncoding.EncodeToString([]byte(config)),
+		base64.StdEncoding.EncodeToString([]byte(secrets)),
+		rsyncPort,
 	)
-	command.Stdin = os.Stdin
-	command.Stdout = nil
-	command.Stderr = nil
-	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true}
-	if err = command.Run(); err != nil {
-		ExitWithError(22, "failed to run session-manager-plugin", err)
+	result, err := runScript(role, instanceId, "bash", script)
+	if err != nil {
+		exitWithSessionError(err)
+	} else if result.ExitCode != 0 {
+		ExitWithError(47, "failed to start rsyncd on the target instance", nil)
 	}
 	if debug {
 		fmt.Printf("Debug: rsync daemon started on port %d\n", rsyncPort)
 	}
 }
 
-func rsyncClean(role *credentials.Role, instanceId string) {
-	var binaryPath string
-	removeOld := "rm -f /tmp/knox-rsyncd.conf;"
-	killOld := "if [ -f /run/knox-rsyncd.pid ]; then kill -9 $(cat /run/knox-rsyncd.pid); rm -f /run/knox-rsyncd.pid; fi;"
-	details, err := role.StartCommand(instanceId, removeOld+killOld)
-	if err != nil {
-		ExitWithError(20, "failed to start ssm session", err)
-	}
-	if binaryPath, err = exec.LookPath("session-manager-plugin"); err != nil {
-		ExitWithError(21, "failed to find session-manager-plugin, see "+SESSION_MANAGER_PLUGIN_URL, err)
-	}
-	command := exec.Command(
-		binaryPath,
-		fmt.Sprintf(`{"SessionId": "%s", "TokenValue": "%s", "StreamUrl": "%s"}`, *details.SessionId, *details.TokenValue, *details.StreamUrl),
-		region,
-		"StartSession",
-		"", // No Profile
-		fmt.Sprintf(`{"Target": "%s"}`, instanceId),
-		fmt.Sprintf("https://ssm.%s.amazonaws.com", region),
-	)
-	command.Stdin = os.Stdin
-	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true}
-	if _, err := command.CombinedOutput(); err != nil {
-		ExitWithError(22, "failed to run session-manager-plugin", err)
-	}
-	if debug {
-		fmt.Println("Debug: removing old temporary rsync config")
+func rsyncClean(role *credentials.Role, instanceId string) error {
+	_, err := runScript(role, instanceId, "bash", RSYNC_CLEAN_SCRIPT)
+	if err == nil && debug {
+		fmt.Println("Debug: removing old temporary rsync config and secrets")
 		fmt.Println("Debug: making sure old rsync daemon is not running")
 	}
+	return err
 }
 
-func rsyncPortForward(role *credentials.Role, instanceId string) {
-	var binaryPath string
-	details, err := role.StartPortForward(instanceId, rsyncPort, localPort)
-	if err != nil {
-		ExitWithError(20, "failed to start ssm session", err)
-	}
-	if binaryPath, err = exec.LookPath("session-manager-plugin"); err != nil {
-		ExitWithError(21, "failed to find session-manager-plugin, see "+SESSION_MANAGER_PLUGIN_URL, err)
+func rsyncPortForward(ctx context.Context, role *credentials.Role, instanceId string, onEvent func(runner.Event)) {
+	request := sessionRequest(role, instanceId, runner.ModePort, func() (*ssm.StartSessionOutput, error) {
+		return role.StartPortForward(region, instanceId, rsyncPort, localPort)
+	})
+	request.LocalPort = localPort
+	request.OnEvent = onEvent
+	if _, err := runSession(ctx, request); err != nil {
+		exitWithSessionError(err)
 	}
-	command := exec.Command(
-		binaryPath,
-		fmt.Sprintf(`{"SessionId": "%s", "TokenValue": "%s", "StreamUrl": "%s"}`, *details.SessionId, *details.TokenValue, *details.StreamUrl),
-		region,
-		"StartSession",
-		"", // No Profile
-		fmt.Sprintf(`{"Target": "%s"}`, instanceId),
-		fmt.Sprintf("https://ssm.%s.amazonaws.com", region),
-	)
-	command.Stdin = os.Stdin
-	command.Stdout = nil
-	command.Stderr = nil
-	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true}
-	if err = command.Run(); err != nil {
-		ExitWithError(22, "failed to run session-manager-plugin", err)
+}
+
+func syncExample(secret string) string {
+	url := fmt.Sprintf("rsync://knox@127.0.0.1:%d/sync", localPort)
+	if syncMode == SyncModePull {
+		return fmt.Sprintf("RSYNC_PASSWORD=%s rsync -P %s/dump.sql ./", secret, url)
 	}
+	return fmt.Sprintf("RSYNC_PASSWORD=%s rsync -P ./dump.sql ./release.tar.gz %s", secret, url)
 }
 
 var syncCmd = &cobra.Command{
 	Use:   "sync [instance-search-term]",
 	Short: "start rsyncd and port forward to it",
-	Run: func(cmd *cobra.Command, args []string) {
-		searchTerm := strings.Join(args, " ")
-		currentSelector := "instance"
-		var err error
-		var role *credentials.Role
-		var action string
-		if lastUsed {
-			var err error
-			var sessions credentials.Sessions
-			var session *credentials.Session
-			var roleTemp credentials.Role
-			if roleTemp, err = credentials.GetLastUsedRole(); err != nil {
-				ExitWithError(1, "failed to get last used role", err)
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if syncMode != SyncModeBoth && syncMode != SyncModePush && syncMode != SyncModePull {
+			return fmt.Errorf("invalid mode %q, must be both, push, or pull", syncMode)
+		}
+		if !strings.HasPrefix(syncRemotePath, "/") && !strings.HasPrefix(syncRemotePath, "~/") {
+			return fmt.Errorf("remote path must be absolute or start with ~/")
+		}
+		if syncWatchDir != "" {
+			if syncMode == SyncModePull {
+				return fmt.Errorf("--watch pushes files and cannot be used with --mode pull")
 			}
-			role = &roleTemp
-			if role.Credentials == nil || role.Credentials.IsExpired() {
-				if sessions, err = credentials.GetSessions(); err != nil {
-					ExitWithError(2, "failed to parse sso sessions", err)
-				}
-				if session = sessions.FindByName(role.SessionName); session == nil {
-					ExitWithError(3, "failed to find sso session "+role.SessionName, err)
-				}
-				if session.ClientToken == nil || session.ClientToken.IsExpired() {
-					if err = tui.ClientLogin(session); err != nil {
-						ExitWithError(4, "failed to authorize device login", err)
-					}
-				}
-				if err = session.RefreshRoleCredentials(role); err != nil {
-					ExitWithError(5, "failed to get credentials", err)
-				}
-				if err = role.Credentials.Save(session.Name, role.CacheKey()); err != nil {
-					ExitWithError(6, "failed to save credentials", err)
-				}
+			if info, err := os.Stat(syncWatchDir); err != nil || !info.IsDir() {
+				return fmt.Errorf("--watch must be a local directory")
 			}
 		}
-		for {
-			if role == nil {
-				if !selectCachedFirst || (sessionName != "" && accountId != "" && roleName != "") {
-					action, role = SelectRoleCredentialsStartingFromSession()
-				} else {
-					action, role = SelectRoleCredentialsStartingFromCache()
-				}
-				if action == "toggle-view" {
-					toggleView()
-					continue
-				}
-				if action == "back" {
-					goBack(&role)
-					continue
-				}
-				if action == "delete" {
-					if role != nil && role.Credentials != nil {
-						role.Credentials.DeleteCache(role.SessionName, role.CacheKey())
-						role = nil
-					}
-					continue
-				}
-			}
-			if region == "" {
-				region = role.Region
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		searchTerm := strings.Join(args, " ")
+		role, _ := SelectRoleAndInstance(searchTerm)
+		secret := rsyncSecret()
+
+		// ExitWithError skips deferred calls, so teardown is an exit hook that
+		// also runs on errors, ctrl+c, and SIGTERM.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		onExit(func() {
+			fmt.Println("\nCleaning up...")
+			if err := rsyncClean(role, instanceId); err != nil {
+				fmt.Println("Warning: failed to stop rsyncd on the target instance, run knox sync again to clean it up")
 			}
-			if instanceId == "" {
-				if currentSelector == "instance" {
-					if instanceId, action, err = tui.SelectInstance(role, region, searchTerm, instanceColTags); err != nil {
-						ExitWithError(19, "failed to pick an instance", err)
-					} else if action == "back" {
-						goBack(&role)
-						continue
-					} else if action == "refresh" {
-						continue
-					} else if action == "pick-region" {
-						currentSelector = "region"
-						continue
-					}
-				} else {
-					pickedRegion := ""
-					if pickedRegion, action, err = tui.SelectRegion(region); err != nil {
-						ExitWithError(20, "failed to pick a region", err)
-					} else if action == "back" {
-						currentSelector = "instance"
-						continue
-					}
-					currentSelector = "instance"
-					region = pickedRegion
-					continue
-				}
+		})
+		abortIfInterrupted := func() {
+			if ctx.Err() != nil {
+				runExitHooks()
+				os.Exit(130)
 			}
+		}
 
-			yellow := color.ToForeground(YellowColor).Decorator()
-			gray := color.ToForeground(LightGrayColor).Decorator()
-			title := TitleStyle.Decorator()
-			DefaultStyle.Printfln("")
-			DefaultStyle.Printfln("%s %s", title("SSO Session:        "), gray(role.SessionName))
-			DefaultStyle.Printfln("%s %s", title("Region:             "), gray(region))
-			DefaultStyle.Printfln("%s %s", title("Account ID:         "), gray(role.AccountId))
-			DefaultStyle.Printfln("%s %s", title("Role Name:          "), gray(role.Name))
-			DefaultStyle.Printfln("%s %s", title("Instance ID:        "), gray(instanceId))
-			DefaultStyle.Printfln("%s %s", title("Remote Destination: "), gray("/root/knox-sync"))
-			DefaultStyle.Printfln("%s %s", title("Example Command:    "), yellow("rsync -P ./dump.sql ./release.tar.gz rsync://127.0.0.1:%d/sync\n", localPort))
-
-			defer rsyncClean(role, instanceId)
-			defer func() {
-				fmt.Println("\nCleaning up...")
-			}()
-			fmt.Println("Starting rsync daemon on target instance...")
-			rsyncClean(role, instanceId)
-			rsyncInit(role, instanceId)
-			rsyncStart(role, instanceId)
-			fmt.Printf("Port forwarding to 127.0.0.1:%d...\n", localPort)
-			rsyncPortForward(role, instanceId)
-			break
+		fmt.Println("Starting rsync daemon on target instance...")
+		if err := rsyncClean(role, instanceId); err != nil {
+			exitWithSessionError(err)
 		}
+		abortIfInterrupted()
+		remotePath, group := rsyncInit(role, instanceId)
+		abortIfInterrupted()
+		rsyncStart(role, instanceId, remotePath, group, secret)
+		abortIfInterrupted()
+
+		yellow := color.ToForeground(YellowColor).Decorator()
+		gray := color.ToForeground(LightGrayColor).Decorator()
+		title := TitleStyle.Decorator()
+		DefaultStyle.Printfln("")
+		DefaultStyle.Printfln("%s %s", title("SSO Session:        "), gray(role.SessionName))
+		DefaultStyle.Printfln("%s %s", title("Region:             "), gray(region))
+		DefaultStyle.Printfln("%s %s", title("Account ID:         "), gray(role.AccountId))
+		DefaultStyle.Printfln("%s %s", title("Role Name:          "), gray(role.Name))
+		DefaultStyle.Printfln("%s %s", title("Instance ID:        "), gray(instanceId))
+		DefaultStyle.Printfln("%s %s", title("Remote Destination: "), gray(remotePath))
+		DefaultStyle.Printfln("%s %s", title("Remote User:        "), gray(syncUser))
+		DefaultStyle.Printfln("%s %s", title("Mode:               "), gray(syncMode))
+		DefaultStyle.Printfln("%s %s", title("Example Command:    "), yellow("%s\n", syncExample(secret)))
+
+		fmt.Printf("Port forwarding to 127.0.0.1:%d...\n", localPort)
+		watching := false
+		rsyncPortForward(ctx, role, instanceId, func(event runner.Event) {
+			if event == runner.EventWaiting && syncWatchDir != "" && !watching {
+				watching = true
+				go watchAndSync(ctx, syncWatchDir, secret)
+			}
+		})
+		runExitHooks()
 	},
 }
 
@@ -288,8 +252,17 @@ func init() {
 	syncCmd.Flags().StringVarP(&accountId, "account-id", "a", accountId, "AWS account ID")
 	syncCmd.Flags().StringVarP(&roleName, "role-name", "r", roleName, "AWS role name")
 	syncCmd.Flags().StringVarP(&instanceId, "instance-id", "i", instanceId, "EC2 instance ID")
-	syncCmd.Flags().StringVar(&region, "region", region, "Region for quering instances")
+	syncCmd.Flags().StringVar(&region, "region", region, "Region for querying instances and starting sessions")
+	syncCmd.Flags().StringArrayVar(&filterFlags, "filter", filterFlags, "Instance filter in the form name=value[,value] (repeatable)")
+	syncCmd.Flags().BoolVar(&allRegions, "all-regions", allRegions, "Search instances across all enabled regions")
 	syncCmd.Flags().Uint16VarP(&rsyncPort, "rsync-port", "P", rsyncPort, "rsync port")
 	syncCmd.Flags().Uint16VarP(&localPort, "local-port", "p", localPort, "local port")
 	syncCmd.Flags().BoolVarP(&lastUsed, "last-used", "l", lastUsed, "select last used credentials")
+	syncCmd.Flags().StringVar(&syncRemotePath, "remote-path", syncRemotePath, "Folder on the instance to sync with, ~/ is the home of --user")
+	syncCmd.Flags().StringVarP(&syncUser, "user", "u", syncUser, "User on the instance that owns synced files")
+	syncCmd.Flags().StringVar(&syncMode, "mode", syncMode, "Allowed transfers, both, push (upload only), or pull (download only)")
+	syncCmd.Flags().StringVarP(&syncWatchDir, "watch", "w", syncWatchDir, "Push this local directory whenever files in it change")
+	syncCmd.Flags().StringArrayVar(&syncWatchIgnores, "ignore", syncWatchIgnores, "Pattern to ignore while watching, in addition to .gitignore files (repeatable)")
+	syncCmd.Flags().BoolVar(&syncWatchDelete, "delete", syncWatchDelete, "Delete remote files that were deleted locally while watching")
+	syncCmd.Flags().DurationVar(&syncWatchDebounce, "debounce", syncWatchDebounce, "Quiet period after a change before pushing")
 }
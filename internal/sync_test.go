@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// The commands the script needs on the instance are stubbed with shell
+// functions, so only the path resolution itself runs.
+const rsyncInitStubs = `
+command() { return 0; }
+id() { if [ "$1" = "-gn" ]; then echo ubuntu; fi; return 0; }
+getent() { echo "ubuntu:x:1000:1000::/home/ubuntu:/bin/bash"; }
+mkdir() { return 0; }
+chown() { return 0; }
+`
+
+func TestRsyncInitScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	tests := []struct {
+		remotePath string
+		quoted     string
+		resolved   string
+	}{
+		{"~/sync", "KNOX_PATH='~/sync';", "/home/ubuntu/sync"},
+		{"~/my dir/$x", `KNOX_PATH='~/my dir/$x';`, "/home/ubuntu/my dir/$x"},
+		{"/srv/app", "KNOX_PATH=/srv/app;", "/srv/app"},
+		{"/srv/it's", `KNOX_PATH='/srv/it'\''s';`, "/srv/it's"},
+	}
+	for _, test := range tests {
+		script := rsyncInitScript("ubuntu", test.remotePath)
+		if !strings.Contains(script, test.quoted) {
+			t.Errorf("%q: script does not contain %s:\n%s", test.remotePath, test.quoted, script)
+			continue
+		}
+		output, err := exec.Command(bash, "-c", rsyncInitStubs+script).CombinedOutput()
+		if err != nil {
+			t.Errorf("%q: script failed: %v\n%s", test.remotePath, err, output)
+			continue
+		}
+		values := map[string]string{}
+		for _, match := range syncOutputLine.FindAllStringSubmatch(string(output), -1) {
+			values[match[1]] = match[2]
+		}
+		if values["KNOX_PATH"] != test.resolved {
+			t.Errorf("%q: resolved to %q, want %q", test.remotePath, values["KNOX_PATH"], test.resolved)
+		}
+		if values["KNOX_GROUP"] != "ubuntu" {
+			t.Errorf("%q: group is %q, want ubuntu", test.remotePath, values["KNOX_GROUP"])
+		}
+	}
+}
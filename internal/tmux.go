@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/credentials"
+	. "github.com/null93/aws-knox/sdk/style"
+	"golang.org/x/term"
+)
+
+const (
+	TmuxLayoutWindows = "windows"
+	TmuxLayoutPanes   = "panes"
+)
+
+var (
+	connectTargets   []string
+	connectTmux      string
+	connectSyncPanes bool
+)
+
+func tmux(args ...string) string {
+	output, err := exec.Command("tmux", args...).CombinedOutput()
+	if err != nil {
+		ExitWithError(62, fmt.Sprintf("tmux %s failed: %s", args[0], strings.TrimSpace(string(output))), err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func tmuxName(instance credentials.Instance) string {
+	if name := strings.TrimSpace(instance.Tags["Name"]); name != "" {
+		return name
+	}
+	return instance.Id
+}
+
+// Every session runs in its own knox connect started with the full role, so
+// credentials are resolved once here and read from the cache by the others.
+func tmuxConnectCommand(role *credentials.Role, instance credentials.Instance, uidChanged bool, command string) string {
+	args := []string{"--instance-id", instance.Id}
+	if uidChanged {
+		args = append(args, "--uid", fmt.Sprintf("%d", connectUid))
+	}
+	if connectUser != "" {
+		args = append(args, "--user", shellQuote(connectUser))
+	}
+	if recordSession {
+		args = append(args, "--record")
+	}
+	if command != "" {
+		args = append(args, "--", shellQuote(command))
+	}
+	return knoxCommand(role, instance.Region, "connect", args...)
+}
+
+// Outside of tmux a detached session sized like the current terminal is
+// created and attached to once every instance has a window or pane.
+func connectInTmux(role *credentials.Role, instances credentials.Instances, uidChanged bool, command string) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		ExitWithError(61, "tmux must be installed to connect to several instances", err)
+	}
+	insideTmux := os.Getenv("TMUX") != ""
+	session := ""
+	windowId := ""
+	gray := color.ToForeground(LightGrayColor).Decorator()
+	DefaultStyle.Printfln("")
+	for i, instance := range instances {
+		name := tmuxName(instance)
+		shellCommand := tmuxConnectCommand(role, instance, uidChanged, command)
+		DefaultStyle.Printfln("Opening %s %s", name, gray(instance.Id))
+		paneId := ""
+		switch {
+		case i == 0 && !insideTmux:
+			session = fmt.Sprintf("knox-%d", os.Getpid())
+			args := []string{"new-session", "-d", "-P", "-F", "#{window_id} #{pane_id}", "-s", session, "-n", name}
+			if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+				args = append(args, "-x", fmt.Sprintf("%d", width), "-y", fmt.Sprintf("%d", height))
+			}
+			windowId, paneId, _ = strings.Cut(tmux(append(args, shellCommand)...), " ")
+		case i == 0 || connectTmux == TmuxLayoutWindows:
+			args := []string{"new-window", "-P", "-F", "#{window_id} #{pane_id}", "-n", name}
+			if session != "" {
+				args = append(args, "-t", session+":")
+			}
+			windowId, paneId, _ = strings.Cut(tmux(append(args, shellCommand)...), " ")
+		default:
+			paneId = tmux("split-window", "-P", "-F", "#{pane_id}", "-t", windowId, shellCommand)
+			tmux("select-layout", "-t", windowId, "tiled")
+		}
+		if connectTmux == TmuxLayoutPanes {
+			tmux("select-pane", "-t", paneId, "-T", name)
+		}
+	}
+	if connectTmux == TmuxLayoutPanes {
+		tmux("rename-window", "-t", windowId, fmt.Sprintf("knox (%d)", len(instances)))
+		tmux("set-window-option", "-t", windowId, "pane-border-status", "top")
+		tmux("set-window-option", "-t", windowId, "pane-border-format", " #{pane_title} ")
+		if connectSyncPanes {
+			tmux("set-window-option", "-t", windowId, "synchronize-panes", "on")
+		}
+	}
+	if !insideTmux {
+		attach := exec.Command("tmux", "attach-session", "-t", session)
+		attach.Stdin, attach.Stdout, attach.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := attach.Run(); err != nil {
+			ExitWithError(62, "failed to attach to tmux session "+session, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/null93/aws-knox/pkg/color"
+	. "github.com/null93/aws-knox/sdk/style"
+)
+
+var (
+	syncWatchDir      string
+	syncWatchIgnores  []string
+	syncWatchDelete   bool
+	syncWatchDebounce = 300 * time.Millisecond
+)
+
+type ignorePattern struct {
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+type ignoreMatcher struct {
+	patterns []ignorePattern
+}
+
+func globToRegexp(glob string) string {
+	result := strings.Builder{}
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			result.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**"):
+			result.WriteString("(/.*)?")
+			i += 2
+		case glob[i] == '*':
+			result.WriteString("[^/]*")
+		case glob[i] == '?':
+			result.WriteString("[^/]")
+		default:
+			result.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	return result.String()
+}
+
+// Patterns follow .gitignore rules closely enough for watching: a pattern
+// without a slash matches at any depth, and the last matching pattern wins.
+func (m *ignoreMatcher) add(base, line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	expression := globToRegexp(strings.TrimPrefix(line, "/"))
+	if !strings.Contains(line, "/") {
+		expression = "(.*/)?" + expression
+	}
+	if compiled, err := regexp.Compile("^" + expression + "$"); err == nil {
+		p.pattern = compiled
+		m.patterns = append(m.patterns, p)
+	}
+}
+
+func (m *ignoreMatcher) load(root, relativeDir string) {
+	file, err := os.Open(filepath.Join(root, relativeDir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		m.add(relativeDir, scanner.Text())
+	}
+}
+
+func (m *ignoreMatcher) matches(relative string, isDir bool) bool {
+	relative = filepath.ToSlash(relative)
+	if relative == ".git" || strings.HasPrefix(relative, ".git/") {
+		return true
+	}
+	ignored := false
+	for _, p := range m.patterns {
+		candidate := relative
+		if p.base != "" {
+			if !strings.HasPrefix(relative, filepath.ToSlash(p.base)+"/") {
+				continue
+			}
+			candidate = strings.TrimPrefix(relative, filepath.ToSlash(p.base)+"/")
+		}
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.pattern.MatchString(candidate) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+func (m *ignoreMatcher) ignored(root, path string) bool {
+	relative, err := filepath.Rel(root, path)
+	if err != nil || relative == "." {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(relative), "/")
+	for i := range parts {
+		info, err := os.Stat(filepath.Join(root, filepath.Join(parts[:i+1]...)))
+		isDir := i < len(parts)-1 || (err == nil && info.IsDir())
+		if m.matches(strings.Join(parts[:i+1], "/"), isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func watchTree(watcher *fsnotify.Watcher, matcher *ignoreMatcher, root, dir string) {
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if matcher.ignored(root, path) {
+			return filepath.SkipDir
+		}
+		relative, _ := filepath.Rel(root, path)
+		if relative != "." {
+			matcher.load(root, relative)
+		}
+		watcher.Add(path)
+		return nil
+	})
+}
+
+func rsyncPush(root, secret string) {
+	gray := color.ToForeground(LightGrayColor).Decorator()
+	yellow := color.ToForeground(YellowColor).Decorator()
+	red := color.ToForeground(RedColor).Decorator()
+	args := []string{"-a", "--out-format=%o %n", "--filter=:- .gitignore", "--exclude=.git"}
+	for _, pattern := range syncWatchIgnores {
+		args = append(args, "--exclude="+pattern)
+	}
+	if syncWatchDelete {
+		args = append(args, "--delete")
+	}
+	args = append(args, strings.TrimSuffix(root, "/")+"/", fmt.Sprintf("rsync://knox@127.0.0.1:%d/sync/", localPort))
+	command := exec.Command("rsync", args...)
+	command.Env = append(os.Environ(), "RSYNC_PASSWORD="+secret)
+	output, err := command.CombinedOutput()
+	timestamp := gray(time.Now().Format("15:04:05"))
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		operation, name, found := strings.Cut(line, " ")
+		switch {
+		case !found || strings.HasSuffix(name, "/"):
+			continue
+		case operation == "send":
+			DefaultStyle.Printfln("%s %s %s", timestamp, yellow("↑"), name)
+		case operation == "del.":
+			DefaultStyle.Printfln("%s %s %s", timestamp, red("✗"), name)
+		}
+	}
+	if err != nil {
+		DefaultStyle.Printfln("%s %s", timestamp, red("rsync failed: %s", strings.TrimSpace(string(output))))
+	}
+}
+
+func watchAndSync(ctx context.Context, root, secret string) {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		ExitWithError(52, "rsync must be installed locally to use --watch", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		ExitWithError(51, "failed to watch "+root, err)
+	}
+	defer watcher.Close()
+	matcher := &ignoreMatcher{}
+	matcher.load(root, "")
+	for _, pattern := range syncWatchIgnores {
+		matcher.add("", pattern)
+	}
+	watchTree(watcher, matcher, root, root)
+	DefaultStyle.Printfln("Watching %s, press ctrl+c to stop", root)
+	rsyncPush(root, secret)
+
+	timer := time.NewTimer(syncWatchDebounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if matcher.ignored(root, event.Name) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchTree(watcher, matcher, root, event.Name)
+				}
+			}
+			if filepath.Base(event.Name) == ".gitignore" {
+				matcher = &ignoreMatcher{}
+				matcher.load(root, "")
+				for _, pattern := range syncWatchIgnores {
+					matcher.add("", pattern)
+				}
+				watchTree(watcher, matcher, root, root)
+			}
+			timer.Reset(syncWatchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			DefaultStyle.Printfln("watch error: %s", err)
+		case <-timer.C:
+			rsyncPush(root, secret)
+		}
+	}
+}
@@ -10,38 +10,108 @@ var (
 	Writer io.Writer = os.Stdout
 )
 
+// Output writes the same escape sequences as the package functions to a
+// writer other than Writer.
+type Output struct {
+	Writer io.Writer
+}
+
+func To(writer io.Writer) Output {
+	return Output{Writer: writer}
+}
+
+func (o Output) HideCursor() {
+	fmt.Fprint(o.Writer, "\033[?25l")
+}
+
+func (o Output) ShowCursor() {
+	fmt.Fprint(o.Writer, "\033[?25h")
+}
+
+func (o Output) SaveCursor() {
+	fmt.Fprint(o.Writer, "\033[s")
+}
+
+func (o Output) RestoreCursor() {
+	fmt.Fprint(o.Writer, "\033[u")
+}
+
+func (o Output) ClearDown() {
+	fmt.Fprint(o.Writer, "\033[J")
+}
+
+func (o Output) ClearRight() {
+	fmt.Fprint(o.Writer, "\033[K")
+}
+
+func (o Output) AlternativeBuffer() {
+	fmt.Fprint(o.Writer, "\033[?1048h")
+}
+
+func (o Output) NormalBuffer() {
+	fmt.Fprint(o.Writer, "\033[?1049l")
+}
+
+func (o Output) MoveCursorUp(n int) {
+	fmt.Fprintf(o.Writer, "\033[%dA", n)
+}
+
+func (o Output) EnableMouse() {
+	fmt.Fprint(o.Writer, "\033[?1000h\033[?1006h")
+}
+
+func (o Output) DisableMouse() {
+	fmt.Fprint(o.Writer, "\033[?1006l\033[?1000l")
+}
+
+func (o Output) QueryCursorPosition() {
+	fmt.Fprint(o.Writer, "\033[6n")
+}
+
 func HideCursor() {
-	fmt.Fprint(Writer, "\033[?25l")
+	To(Writer).HideCursor()
 }
 
 func ShowCursor() {
-	fmt.Fprint(Writer, "\033[?25h")
+	To(Writer).ShowCursor()
 }
 
 func SaveCursor() {
-	fmt.Fprint(Writer, "\033[s")
+	To(Writer).SaveCursor()
 }
 
 func RestoreCursor() {
-	fmt.Fprint(Writer, "\033[u")
+	To(Writer).RestoreCursor()
 }
 
 func ClearDown() {
-	fmt.Fprint(Writer, "\033[J")
+	To(Writer).ClearDown()
 }
 
 func ClearRight() {
-	fmt.Fprint(Writer, "\033[K")
+	To(Writer).ClearRight()
 }
 
 func AlternativeBuffer() {
-	fmt.Fprint(Writer, "\033[?1048h")
+	To(Writer).AlternativeBuffer()
 }
 
 func NormalBuffer() {
-	fmt.Fprint(Writer, "\033[?1049l")
+	To(Writer).NormalBuffer()
 }
 
 func MoveCursorUp(n int) {
-	fmt.Fprintf(Writer, "\033[%dA", n)
+	To(Writer).MoveCursorUp(n)
+}
+
+func EnableMouse() {
+	To(Writer).EnableMouse()
+}
+
+func DisableMouse() {
+	To(Writer).DisableMouse()
+}
+
+func QueryCursorPosition() {
+	To(Writer).QueryCursorPosition()
 }
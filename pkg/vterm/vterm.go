@@ -0,0 +1,403 @@
+package vterm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	Up        = "\033[A"
+	Down      = "\033[B"
+	Right     = "\033[C"
+	Left      = "\033[D"
+	Home      = "\033[H"
+	End       = "\033[F"
+	PgUp      = "\033[5~"
+	PgDown    = "\033[6~"
+	Delete    = "\033[3~"
+	ShiftTab  = "\033[Z"
+	F2        = "\033[12~"
+	Enter     = "\r"
+	Tab       = "\t"
+	Esc       = "\033"
+	Space     = " "
+	Backspace = "\x7f"
+	CtrlA     = "\x01"
+	CtrlC     = "\x03"
+	CtrlG     = "\x07"
+	CtrlN     = "\x0e"
+	CtrlO     = "\x0f"
+	CtrlP     = "\x10"
+	CtrlS     = "\x13"
+	CtrlW     = "\x17"
+)
+
+// Terminal is a virtual terminal for driving interactive output from a
+// script. What is written to it is drawn on a fixed size screen, and what is
+// typed, pressed or clicked is read back from it like from a tty.
+type Terminal struct {
+	mu          sync.Mutex
+	width       int
+	height      int
+	cells       [][]rune
+	row         int
+	col         int
+	savedRow    int
+	savedCol    int
+	wrapPending bool
+	pending     []byte
+	mouse       bool
+	cursor      bool
+	input       [][]byte
+	closed      bool
+	ready       *sync.Cond
+}
+
+func New(width, height int) *Terminal {
+	t := &Terminal{width: width, height: height, cursor: true}
+	t.ready = sync.NewCond(&t.mu)
+	t.cells = make([][]rune, height)
+	for i := range t.cells {
+		t.cells[i] = t.blankLine()
+	}
+	return t
+}
+
+func (t *Terminal) Size() (int, int) {
+	return t.width, t.height
+}
+
+func (t *Terminal) MouseEnabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mouse
+}
+
+func (t *Terminal) CursorVisible() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cursor
+}
+
+// Screen returns the visible text, with trailing spaces and empty lines at the
+// bottom left out.
+func (t *Terminal) Screen() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := []string{}
+	for _, cells := range t.cells {
+		line := strings.Builder{}
+		for _, r := range cells {
+			if r != 0 {
+				line.WriteRune(r)
+			}
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *Terminal) Type(text string) {
+	t.send([]byte(text))
+}
+
+// Each key is sent on its own, the same way they arrive when typed.
+func (t *Terminal) Press(keys ...string) {
+	for _, key := range keys {
+		t.send([]byte(key))
+	}
+}
+
+// Mouse reports use 1-based columns and rows, like the terminal does.
+func (t *Terminal) Click(x, y int) {
+	t.send([]byte(fmt.Sprintf("\033[<0;%d;%dM\033[<0;%d;%dm", x, y, x, y)))
+}
+
+func (t *Terminal) ScrollUp(x, y int) {
+	t.send([]byte(fmt.Sprintf("\033[<64;%d;%dM", x, y)))
+}
+
+func (t *Terminal) ScrollDown(x, y int) {
+	t.send([]byte(fmt.Sprintf("\033[<65;%d;%dM", x, y)))
+}
+
+// WaitFor waits until the text is on screen, output is written from other
+// goroutines so the screen is checked until the timeout runs out.
+func (t *Terminal) WaitFor(text string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		screen := t.Screen()
+		if strings.Contains(screen, text) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %q, screen was:\n%s", text, screen)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// MatchGolden compares the screen with the contents of a golden file, which
+// is written instead when update is set.
+func (t *Terminal) MatchGolden(path string, update bool) error {
+	screen := t.Screen() + "\n"
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(screen), 0644)
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if string(golden) == screen {
+		return nil
+	}
+	return fmt.Errorf("screen does not match %s:\n%s", path, diffLines(string(golden), screen))
+}
+
+func diffLines(expected, actual string) string {
+	a, b := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	diff := strings.Builder{}
+	for i := 0; i < max(len(a), len(b)); i++ {
+		switch {
+		case i >= len(a):
+			fmt.Fprintf(&diff, "+ %s\n", b[i])
+		case i >= len(b):
+			fmt.Fprintf(&diff, "- %s\n", a[i])
+		case a[i] != b[i]:
+			fmt.Fprintf(&diff, "- %s\n+ %s\n", a[i], b[i])
+		default:
+			fmt.Fprintf(&diff, "  %s\n", a[i])
+		}
+	}
+	return diff.String()
+}
+
+// Read blocks until something is typed, pressed or clicked, and returns
+// io.EOF once the terminal is closed.
+func (t *Terminal) Read(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.input) < 1 && !t.closed {
+		t.ready.Wait()
+	}
+	if len(t.input) < 1 {
+		return 0, io.EOF
+	}
+	n := copy(b, t.input[0])
+	if n < len(t.input[0]) {
+		t.input[0] = t.input[0][n:]
+	} else {
+		t.input = t.input[1:]
+	}
+	return n, nil
+}
+
+func (t *Terminal) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.ready.Broadcast()
+	return nil
+}
+
+func (t *Terminal) send(b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.input = append(t.input, b)
+	t.ready.Broadcast()
+}
+
+func (t *Terminal) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data := append(t.pending, b...)
+	t.pending = nil
+	for len(data) > 0 {
+		size := t.process(data)
+		if size == 0 {
+			t.pending = append([]byte{}, data...)
+			break
+		}
+		data = data[size:]
+	}
+	return len(b), nil
+}
+
+// Returns how many bytes were used, or 0 when the data ends in the middle of
+// an escape sequence or a rune.
+func (t *Terminal) process(data []byte) int {
+	switch data[0] {
+	case 0x1b:
+		return t.escape(data)
+	case '\r':
+		t.col, t.wrapPending = 0, false
+	case '\n':
+		// Output processing is left on by the picker, so a newline also
+		// returns the cursor to the start of the line.
+		t.col, t.wrapPending = 0, false
+		t.lineFeed()
+	case '\b':
+		t.col, t.wrapPending = max(t.col-1, 0), false
+	case '\t':
+		t.col, t.wrapPending = min((t.col/8+1)*8, t.width-1), false
+	default:
+		if data[0] < 0x20 || data[0] == 0x7f {
+			return 1
+		}
+		if !utf8.FullRune(data) {
+			return 0
+		}
+		r, size := utf8.DecodeRune(data)
+		t.put(r)
+		return size
+	}
+	return 1
+}
+
+func (t *Terminal) escape(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	if data[1] != '[' {
+		return 2
+	}
+	end := -1
+	for i := 2; i < len(data); i++ {
+		if data[i] >= 0x40 && data[i] <= 0x7e {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return 0
+	}
+	t.csi(string(data[2:end]), data[end])
+	return end + 1
+}
+
+func (t *Terminal) csi(params string, final byte) {
+	private := strings.HasPrefix(params, "?")
+	values := []int{}
+	for _, field := range strings.Split(strings.TrimPrefix(params, "?"), ";") {
+		value, _ := strconv.Atoi(field)
+		values = append(values, value)
+	}
+	n := max(values[0], 1)
+	if final != 'm' {
+		t.wrapPending = false
+	}
+	switch {
+	case private && (final == 'h' || final == 'l'):
+		for _, mode := range values {
+			switch mode {
+			case 25:
+				t.cursor = final == 'h'
+			case 1000:
+				t.mouse = final == 'h'
+			}
+		}
+	case final == 'A':
+		t.row = max(t.row-n, 0)
+	case final == 'B':
+		t.row = min(t.row+n, t.height-1)
+	case final == 'C':
+		t.col = min(t.col+n, t.width-1)
+	case final == 'D':
+		t.col = max(t.col-n, 0)
+	case final == 'H' || final == 'f':
+		t.row = min(n, t.height) - 1
+		t.col = 0
+		if len(values) > 1 {
+			t.col = min(max(values[1], 1), t.width) - 1
+		}
+	case final == 'J':
+		t.clearScreen(values[0])
+	case final == 'K':
+		t.clearLine(values[0])
+	case final == 's':
+		t.savedRow, t.savedCol = t.row, t.col
+	case final == 'u':
+		t.row, t.col = t.savedRow, t.savedCol
+	case final == 'n' && values[0] == 6:
+		t.input = append(t.input, []byte(fmt.Sprintf("\033[%d;%dR", t.row+1, t.col+1)))
+		t.ready.Broadcast()
+	}
+}
+
+func (t *Terminal) put(r rune) {
+	width := runewidth.RuneWidth(r)
+	if width < 1 {
+		return
+	}
+	if t.wrapPending || t.col+width > t.width {
+		t.col, t.wrapPending = 0, false
+		t.lineFeed()
+	}
+	t.cells[t.row][t.col] = r
+	if width > 1 {
+		t.cells[t.row][t.col+1] = 0
+	}
+	t.col += width
+	if t.col >= t.width {
+		t.col, t.wrapPending = t.width-1, true
+	}
+}
+
+func (t *Terminal) lineFeed() {
+	if t.row < t.height-1 {
+		t.row++
+		return
+	}
+	t.cells = append(t.cells[1:], t.blankLine())
+}
+
+func (t *Terminal) clearScreen(mode int) {
+	switch mode {
+	case 0:
+		t.clearLine(0)
+		for i := t.row + 1; i < t.height; i++ {
+			t.cells[i] = t.blankLine()
+		}
+	case 1:
+		t.clearLine(1)
+		for i := 0; i < t.row; i++ {
+			t.cells[i] = t.blankLine()
+		}
+	default:
+		for i := range t.cells {
+			t.cells[i] = t.blankLine()
+		}
+	}
+}
+
+func (t *Terminal) clearLine(mode int) {
+	from, to := t.col, t.width
+	switch mode {
+	case 1:
+		from, to = 0, t.col+1
+	case 2:
+		from = 0
+	}
+	for i := from; i < min(to, t.width); i++ {
+		t.cells[t.row][i] = ' '
+	}
+}
+
+func (t *Terminal) blankLine() []rune {
+	return []rune(strings.Repeat(" ", t.width))
+}
@@ -0,0 +1,477 @@
+package channel
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Versions newer than 1.1.70 make the agent multiplex port sessions, which
+// this client does not implement, so port sessions carry a single stream.
+const (
+	ClientVersion          = "1.1.61.0"
+	SessionTypeStandard    = "Standard_Stream"
+	SessionTypePort        = "Port"
+	SessionTypeInteractive = "InteractiveCommands"
+)
+
+const (
+	streamDataPayloadSize = 1024
+	resendInterval        = 500 * time.Millisecond
+	resendTimeout         = 2 * time.Second
+)
+
+const (
+	actionStatusSuccess     = 1
+	actionStatusFailed      = 2
+	actionStatusUnsupported = 3
+)
+
+var (
+	ErrEncryptionNotSupported = fmt.Errorf("session encryption is not supported by the native session client")
+	ErrConnectToPort          = fmt.Errorf("agent failed to connect to the remote port")
+	ErrChannelClosed          = fmt.Errorf("data channel is closed")
+)
+
+type openDataChannelInput struct {
+	MessageSchemaVersion string `json:"MessageSchemaVersion"`
+	RequestId            string `json:"RequestId"`
+	TokenValue           string `json:"TokenValue"`
+	ClientId             string `json:"ClientId"`
+	ClientVersion        string `json:"ClientVersion"`
+}
+
+type requestedClientAction struct {
+	ActionType       string          `json:"ActionType"`
+	ActionParameters json.RawMessage `json:"ActionParameters"`
+}
+
+type handshakeRequest struct {
+	AgentVersion           string                  `json:"AgentVersion"`
+	RequestedClientActions []requestedClientAction `json:"RequestedClientActions"`
+}
+
+type sessionTypeRequest struct {
+	SessionType string          `json:"SessionType"`
+	Properties  json.RawMessage `json:"Properties"`
+}
+
+type processedClientAction struct {
+	ActionType   string      `json:"ActionType"`
+	ActionStatus int         `json:"ActionStatus"`
+	ActionResult interface{} `json:"ActionResult"`
+	Error        string      `json:"Error"`
+}
+
+type handshakeResponse struct {
+	ClientVersion          string                  `json:"ClientVersion"`
+	ProcessedClientActions []processedClientAction `json:"ProcessedClientActions"`
+	Errors                 []string                `json:"Errors"`
+}
+
+type acknowledgeContent struct {
+	MessageType         string `json:"AcknowledgedMessageType"`
+	MessageId           string `json:"AcknowledgedMessageId"`
+	SequenceNumber      int64  `json:"AcknowledgedMessageSequenceNumber"`
+	IsSequentialMessage bool   `json:"IsSequentialMessage"`
+}
+
+type channelClosed struct {
+	SessionId string `json:"SessionId"`
+	Output    string `json:"Output"`
+}
+
+type terminalSize struct {
+	Cols uint32 `json:"cols"`
+	Rows uint32 `json:"rows"`
+}
+
+type pendingMessage struct {
+	message *Message
+	sentAt  time.Time
+}
+
+type Channel struct {
+	Stderr      io.Writer
+	conn        *websocket.Conn
+	writeMutex  sync.Mutex
+	sendMutex   sync.Mutex
+	mutex       sync.Mutex
+	sequence    int64
+	expected    int64
+	buffered    map[int64]*Message
+	unacked     map[int64]*pendingMessage
+	reader      *io.PipeReader
+	writer      *io.PipeWriter
+	sessionType string
+	properties  json.RawMessage
+	exitCode    int
+	closeOutput string
+	err         error
+	ready       chan struct{}
+	done        chan struct{}
+	readyOnce   sync.Once
+	doneOnce    sync.Once
+}
+
+func Open(ctx context.Context, streamUrl, tokenValue string) (*Channel, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Channel{
+		conn:     conn,
+		buffered: map[int64]*Message{},
+		unacked:  map[int64]*pendingMessage{},
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.reader, c.writer = io.Pipe()
+	input := openDataChannelInput{
+		MessageSchemaVersion: "1.0",
+		RequestId:            NewUUID().String(),
+		TokenValue:           tokenValue,
+		ClientId:             NewUUID().String(),
+		ClientVersion:        ClientVersion,
+	}
+	c.writeMutex.Lock()
+	err = conn.WriteJSON(input)
+	c.writeMutex.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop()
+	go c.resendLoop()
+	select {
+	case <-c.ready:
+		return c, nil
+	case <-c.done:
+		if c.err == nil {
+			return nil, ErrChannelClosed
+		}
+		return nil, c.err
+	case <-ctx.Done():
+		c.finish(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Channel) SessionType() string {
+	return c.sessionType
+}
+
+func (c *Channel) Properties() json.RawMessage {
+	return c.properties
+}
+
+func (c *Channel) ExitCode() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.exitCode
+}
+
+func (c *Channel) CloseOutput() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeOutput
+}
+
+func (c *Channel) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Channel) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *Channel) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *Channel) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := min(len(p), streamDataPayloadSize)
+		if err := c.send(PayloadTypeOutput, p[:size]); err != nil {
+			return written, err
+		}
+		written += size
+		p = p[size:]
+	}
+	return written, nil
+}
+
+func (c *Channel) Resize(cols, rows int) error {
+	payload, err := json.Marshal(terminalSize{Cols: uint32(cols), Rows: uint32(rows)})
+	if err != nil {
+		return err
+	}
+	return c.send(PayloadTypeSize, payload)
+}
+
+func (c *Channel) SendFlag(flag PayloadFlag) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(flag))
+	return c.send(PayloadTypeFlag, payload)
+}
+
+func (c *Channel) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if c.sessionType == SessionTypePort {
+		c.SendFlag(FlagTerminateSession)
+	}
+	c.writeMutex.Lock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMutex.Unlock()
+	c.finish(nil)
+	return nil
+}
+
+func (c *Channel) finish(err error) {
+	c.doneOnce.Do(func() {
+		c.err = err
+		c.writer.CloseWithError(err)
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Channel) markReady() {
+	c.readyOnce.Do(func() {
+		close(c.ready)
+	})
+}
+
+func (c *Channel) write(message *Message) error {
+	data, err := message.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (c *Channel) send(payloadType PayloadType, payload []byte) error {
+	select {
+	case <-c.done:
+		return ErrChannelClosed
+	default:
+	}
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	c.mutex.Lock()
+	message := &Message{
+		MessageType:    MessageTypeInputStreamData,
+		SchemaVersion:  1,
+		CreatedDate:    uint64(time.Now().UnixMilli()),
+		SequenceNumber: c.sequence,
+		Flags:          MessageFlagData,
+		MessageId:      NewUUID(),
+		PayloadType:    payloadType,
+		Payload:        append([]byte{}, payload...),
+	}
+	c.sequence++
+	c.unacked[message.SequenceNumber] = &pendingMessage{message, time.Now()}
+	c.mutex.Unlock()
+	return c.write(message)
+}
+
+func (c *Channel) acknowledge(message *Message) error {
+	payload, err := json.Marshal(acknowledgeContent{
+		MessageType:         message.MessageType,
+		MessageId:           message.MessageId.String(),
+		SequenceNumber:      message.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	if err != nil {
+		return err
+	}
+	return c.write(&Message{
+		MessageType:   MessageTypeAcknowledge,
+		SchemaVersion: 1,
+		CreatedDate:   uint64(time.Now().UnixMilli()),
+		Flags:         MessageFlagAck,
+		MessageId:     NewUUID(),
+		Payload:       payload,
+	})
+}
+
+func (c *Channel) resendLoop() {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mutex.Lock()
+			expired := []*Message{}
+			for _, pending := range c.unacked {
+				if time.Since(pending.sentAt) > resendTimeout {
+					pending.sentAt = time.Now()
+					expired = append(expired, pending.message)
+				}
+			}
+			c.mutex.Unlock()
+			for _, message := range expired {
+				c.write(message)
+			}
+		}
+	}
+}
+
+func (c *Channel) readLoop() {
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				err = nil
+			}
+			c.finish(err)
+			return
+		}
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		message := &Message{}
+		if err := message.UnmarshalBinary(data); err != nil {
+			continue
+		}
+		switch message.MessageType {
+		case MessageTypeOutputStreamData:
+			c.handleOutput(message)
+		case MessageTypeAcknowledge:
+			c.handleAcknowledge(message)
+		case MessageTypeChannelClosed:
+			c.handleChannelClosed(message)
+			return
+		}
+	}
+}
+
+func (c *Channel) handleOutput(message *Message) {
+	c.acknowledge(message)
+	c.mutex.Lock()
+	if message.SequenceNumber < c.expected {
+		c.mutex.Unlock()
+		return
+	}
+	c.buffered[message.SequenceNumber] = message
+	ordered := []*Message{}
+	for {
+		next, ok := c.buffered[c.expected]
+		if !ok {
+			break
+		}
+		delete(c.buffered, c.expected)
+		ordered = append(ordered, next)
+		c.expected++
+	}
+	c.mutex.Unlock()
+	for _, next := range ordered {
+		c.process(next)
+	}
+}
+
+func (c *Channel) handleAcknowledge(message *Message) {
+	content := acknowledgeContent{}
+	if err := json.Unmarshal(message.Payload, &content); err != nil {
+		return
+	}
+	c.mutex.Lock()
+	delete(c.unacked, content.SequenceNumber)
+	c.mutex.Unlock()
+}
+
+func (c *Channel) handleChannelClosed(message *Message) {
+	content := channelClosed{}
+	json.Unmarshal(message.Payload, &content)
+	c.mutex.Lock()
+	c.closeOutput = content.Output
+	c.mutex.Unlock()
+	c.finish(nil)
+}
+
+func (c *Channel) process(message *Message) {
+	switch message.PayloadType {
+	case PayloadTypeOutput:
+		c.markReady()
+		c.writer.Write(message.Payload)
+	case PayloadTypeStdErr:
+		c.markReady()
+		if c.Stderr != nil {
+			c.Stderr.Write(message.Payload)
+		} else {
+			c.writer.Write(message.Payload)
+		}
+	case PayloadTypeExitCode:
+		if code, err := strconv.Atoi(strings.TrimSpace(string(message.Payload))); err == nil {
+			c.mutex.Lock()
+			c.exitCode = code
+			c.mutex.Unlock()
+		}
+	case PayloadTypeHandshakeRequest:
+		c.handleHandshakeRequest(message.Payload)
+	case PayloadTypeHandshakeComplete:
+		c.markReady()
+	case PayloadTypeEncChallengeRequest:
+		c.finish(ErrEncryptionNotSupported)
+	case PayloadTypeFlag:
+		if len(message.Payload) >= 4 && PayloadFlag(binary.BigEndian.Uint32(message.Payload)) == FlagConnectToPortError {
+			c.finish(ErrConnectToPort)
+		}
+	}
+}
+
+func (c *Channel) handleHandshakeRequest(payload []byte) {
+	request := handshakeRequest{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		c.finish(err)
+		return
+	}
+	encrypted := false
+	response := handshakeResponse{ClientVersion: ClientVersion, Errors: []string{}}
+	for _, action := range request.RequestedClientActions {
+		processed := processedClientAction{ActionType: action.ActionType, ActionStatus: actionStatusSuccess}
+		switch action.ActionType {
+		case "SessionType":
+			params := sessionTypeRequest{}
+			if err := json.Unmarshal(action.ActionParameters, &params); err != nil {
+				processed.ActionStatus = actionStatusFailed
+				processed.Error = err.Error()
+				break
+			}
+			c.sessionType = params.SessionType
+			c.properties = params.Properties
+		case "KMSEncryption":
+			encrypted = true
+			processed.ActionStatus = actionStatusFailed
+			processed.Error = ErrEncryptionNotSupported.Error()
+		default:
+			processed.ActionStatus = actionStatusUnsupported
+			processed.Error = fmt.Sprintf("unsupported action %s", action.ActionType)
+		}
+		response.ProcessedClientActions = append(response.ProcessedClientActions, processed)
+	}
+	if encoded, err := json.Marshal(response); err == nil {
+		c.send(PayloadTypeHandshakeResponse, encoded)
+	}
+	if encrypted {
+		c.finish(ErrEncryptionNotSupported)
+	}
+}
@@ -0,0 +1,415 @@
+package channel
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const agentTimeout = 5 * time.Second
+
+// agent stands in for the SSM agent on the other end of the data channel,
+// each test drives it by hand from the client's point of view.
+type agent struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	open     openDataChannelInput
+	sequence int64
+	done     chan struct{}
+}
+
+func startAgent(t *testing.T) (string, <-chan *agent) {
+	agents := make(chan *agent, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %v", err)
+			return
+		}
+		a := &agent{t: t, conn: conn, done: make(chan struct{})}
+		conn.SetReadDeadline(time.Now().Add(agentTimeout))
+		if err := conn.ReadJSON(&a.open); err != nil {
+			t.Errorf("failed to read open data channel input: %v", err)
+			conn.Close()
+			return
+		}
+		agents <- a
+		<-a.done
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), agents
+}
+
+// openChannel opens a channel against a new agent, and runs the handshake
+// function on the agent while the client waits for the channel to be ready.
+func openChannel(t *testing.T, handshake func(a *agent)) (*Channel, *agent, error) {
+	url, agents := startAgent(t)
+	type opened struct {
+		c   *Channel
+		err error
+	}
+	result := make(chan opened, 1)
+	go func() {
+		c, err := Open(context.Background(), url, "token")
+		result <- opened{c, err}
+	}()
+	var a *agent
+	select {
+	case a = <-agents:
+	case <-time.After(agentTimeout):
+		t.Fatal("client did not connect")
+	}
+	t.Cleanup(func() { close(a.done) })
+	handshake(a)
+	select {
+	case r := <-result:
+		if r.c != nil {
+			t.Cleanup(func() { r.c.Close() })
+		}
+		return r.c, a, r.err
+	case <-time.After(agentTimeout):
+		t.Fatal("channel did not open")
+	}
+	return nil, nil, nil
+}
+
+func (a *agent) sendAt(sequence int64, payloadType PayloadType, payload []byte) {
+	a.t.Helper()
+	a.sendMessage(&Message{
+		MessageType:    MessageTypeOutputStreamData,
+		SchemaVersion:  1,
+		SequenceNumber: sequence,
+		MessageId:      NewUUID(),
+		PayloadType:    payloadType,
+		Payload:        payload,
+	})
+}
+
+func (a *agent) send(payloadType PayloadType, payload []byte) {
+	a.t.Helper()
+	a.sendAt(a.sequence, payloadType, payload)
+	a.sequence++
+}
+
+func (a *agent) sendMessage(message *Message) {
+	a.t.Helper()
+	data, err := message.MarshalBinary()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if err := a.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func (a *agent) read() *Message {
+	a.t.Helper()
+	a.conn.SetReadDeadline(time.Now().Add(agentTimeout))
+	_, data, err := a.conn.ReadMessage()
+	if err != nil {
+		a.t.Fatalf("failed to read from client: %v", err)
+	}
+	message := &Message{}
+	if err := message.UnmarshalBinary(data); err != nil {
+		a.t.Fatalf("failed to unmarshal client message: %v", err)
+	}
+	return message
+}
+
+// readInput skips the client's acknowledgements and returns the next input,
+// which is acknowledged unless the test wants it to be resent.
+func (a *agent) readInput(ack bool) *Message {
+	a.t.Helper()
+	for {
+		message := a.read()
+		if message.MessageType == MessageTypeAcknowledge {
+			continue
+		}
+		if message.MessageType != MessageTypeInputStreamData {
+			a.t.Fatalf("unexpected message type %q", message.MessageType)
+		}
+		if ack {
+			a.ack(message)
+		}
+		return message
+	}
+}
+
+func (a *agent) ack(message *Message) {
+	a.t.Helper()
+	payload, _ := json.Marshal(acknowledgeContent{
+		MessageType:         message.MessageType,
+		MessageId:           message.MessageId.String(),
+		SequenceNumber:      message.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	a.sendMessage(&Message{
+		MessageType:   MessageTypeAcknowledge,
+		SchemaVersion: 1,
+		Flags:         MessageFlagAck,
+		MessageId:     NewUUID(),
+		Payload:       payload,
+	})
+}
+
+func (a *agent) handshake(actions ...requestedClientAction) handshakeResponse {
+	a.t.Helper()
+	request, _ := json.Marshal(handshakeRequest{AgentVersion: "3.3.0.0", RequestedClientActions: actions})
+	a.send(PayloadTypeHandshakeRequest, request)
+	message := a.readInput(true)
+	if message.PayloadType != PayloadTypeHandshakeResponse {
+		a.t.Fatalf("expected handshake response, got payload type %d", message.PayloadType)
+	}
+	response := handshakeResponse{}
+	if err := json.Unmarshal(message.Payload, &response); err != nil {
+		a.t.Fatal(err)
+	}
+	return response
+}
+
+func sessionTypeAction(sessionType, properties string) requestedClientAction {
+	parameters, _ := json.Marshal(sessionTypeRequest{SessionType: sessionType, Properties: json.RawMessage(properties)})
+	return requestedClientAction{ActionType: "SessionType", ActionParameters: parameters}
+}
+
+func flagPayload(flag PayloadFlag) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(flag))
+	return payload
+}
+
+func openPortChannel(t *testing.T) (*Channel, *agent) {
+	c, a, err := openChannel(t, func(a *agent) {
+		a.handshake(sessionTypeAction(SessionTypePort, `{"portNumber":"80"}`))
+		a.send(PayloadTypeHandshakeComplete, []byte(`{}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, a
+}
+
+func readFull(t *testing.T, r io.Reader, size int) string {
+	t.Helper()
+	buffer := make([]byte, size)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(r, buffer)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(agentTimeout):
+		t.Fatalf("timed out reading %d bytes", size)
+	}
+	return string(buffer)
+}
+
+func TestHandshake(t *testing.T) {
+	var response handshakeResponse
+	c, a, err := openChannel(t, func(a *agent) {
+		response = a.handshake(
+			sessionTypeAction(SessionTypePort, `{"portNumber":"80"}`),
+			requestedClientAction{ActionType: "Unknown"},
+		)
+		a.send(PayloadTypeHandshakeComplete, []byte(`{}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.open.TokenValue != "token" || a.open.ClientVersion != ClientVersion || a.open.MessageSchemaVersion != "1.0" {
+		t.Errorf("unexpected open data channel input: %+v", a.open)
+	}
+	if c.SessionType() != SessionTypePort {
+		t.Errorf("session type is %q, want %q", c.SessionType(), SessionTypePort)
+	}
+	if string(c.Properties()) != `{"portNumber":"80"}` {
+		t.Errorf("properties are %s", c.Properties())
+	}
+	if response.ClientVersion != ClientVersion || len(response.ProcessedClientActions) != 2 {
+		t.Fatalf("unexpected handshake response: %+v", response)
+	}
+	if status := response.ProcessedClientActions[0].ActionStatus; status != actionStatusSuccess {
+		t.Errorf("session type action status is %d, want %d", status, actionStatusSuccess)
+	}
+	if status := response.ProcessedClientActions[1].ActionStatus; status != actionStatusUnsupported {
+		t.Errorf("unknown action status is %d, want %d", status, actionStatusUnsupported)
+	}
+}
+
+func TestOutputIsOrderedAndAcknowledged(t *testing.T) {
+	c, a := openPortChannel(t)
+	first, second := a.sequence, a.sequence+1
+	a.sendAt(second, PayloadTypeOutput, []byte("world"))
+	a.sendAt(first, PayloadTypeOutput, []byte("hello "))
+	// Duplicates of delivered messages are acknowledged but dropped.
+	a.sendAt(first, PayloadTypeOutput, []byte("hello "))
+	a.sendAt(second+1, PayloadTypeOutput, []byte("!"))
+	if output := readFull(t, c, len("hello world!")); output != "hello world!" {
+		t.Errorf("output is %q", output)
+	}
+	acknowledged := map[int64]int{}
+	for len(acknowledged) < 3 || acknowledged[first] < 2 {
+		message := a.read()
+		if message.MessageType != MessageTypeAcknowledge {
+			continue
+		}
+		content := acknowledgeContent{}
+		if err := json.Unmarshal(message.Payload, &content); err != nil {
+			t.Fatal(err)
+		}
+		acknowledged[content.SequenceNumber]++
+	}
+}
+
+func TestUnacknowledgedInputIsResent(t *testing.T) {
+	c, a := openPortChannel(t)
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	sent := a.readInput(false)
+	resent := a.readInput(true)
+	if resent.SequenceNumber != sent.SequenceNumber || resent.MessageId != sent.MessageId || string(resent.Payload) != "ping" {
+		t.Errorf("resent %+v, want %+v", resent, sent)
+	}
+	if _, err := c.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	next := a.readInput(true)
+	if next.SequenceNumber != sent.SequenceNumber+1 || string(next.Payload) != "pong" {
+		t.Errorf("next input is %d %q", next.SequenceNumber, next.Payload)
+	}
+}
+
+func TestWriteIsSplitIntoPayloads(t *testing.T) {
+	c, a := openPortChannel(t)
+	data := bytes.Repeat([]byte("x"), streamDataPayloadSize*2+10)
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	received := []byte{}
+	for _, size := range []int{streamDataPayloadSize, streamDataPayloadSize, 10} {
+		message := a.readInput(true)
+		if len(message.Payload) != size || message.PayloadType != PayloadTypeOutput {
+			t.Fatalf("payload of type %d has %d bytes, want %d", message.PayloadType, len(message.Payload), size)
+		}
+		received = append(received, message.Payload...)
+	}
+	if !bytes.Equal(received, data) {
+		t.Error("received data does not match")
+	}
+}
+
+func TestResize(t *testing.T) {
+	c, a := openPortChannel(t)
+	if err := c.Resize(120, 40); err != nil {
+		t.Fatal(err)
+	}
+	message := a.readInput(true)
+	if message.PayloadType != PayloadTypeSize {
+		t.Fatalf("payload type is %d, want %d", message.PayloadType, PayloadTypeSize)
+	}
+	size := terminalSize{}
+	if err := json.Unmarshal(message.Payload, &size); err != nil {
+		t.Fatal(err)
+	}
+	if size.Cols != 120 || size.Rows != 40 {
+		t.Errorf("size is %+v", size)
+	}
+}
+
+func TestForwardDisconnectsFromPort(t *testing.T) {
+	c, a := openPortChannel(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan struct{}, 2)
+	go c.Forward(listener, func() { accepted <- struct{}{} })
+	for _, request := range []string{"first", "second"} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(request))
+		message := a.readInput(true)
+		if string(message.Payload) != request {
+			t.Fatalf("agent received %q, want %q", message.Payload, request)
+		}
+		a.send(PayloadTypeOutput, []byte("reply to "+request))
+		if reply := readFull(t, conn, len("reply to "+request)); reply != "reply to "+request {
+			t.Errorf("connection received %q", reply)
+		}
+		conn.Close()
+		message = a.readInput(true)
+		if message.PayloadType != PayloadTypeFlag || !bytes.Equal(message.Payload, flagPayload(FlagDisconnectToPort)) {
+			t.Fatalf("expected disconnect flag, got payload type %d %v", message.PayloadType, message.Payload)
+		}
+		<-accepted
+	}
+}
+
+func TestConnectToPortError(t *testing.T) {
+	c, a := openPortChannel(t)
+	a.send(PayloadTypeFlag, flagPayload(FlagConnectToPortError))
+	if err := c.Err(); !errors.Is(err, ErrConnectToPort) {
+		t.Errorf("error is %v, want %v", err, ErrConnectToPort)
+	}
+}
+
+func TestEncryptionNotSupported(t *testing.T) {
+	var response handshakeResponse
+	_, _, err := openChannel(t, func(a *agent) {
+		response = a.handshake(
+			sessionTypeAction(SessionTypeStandard, `{}`),
+			requestedClientAction{ActionType: "KMSEncryption", ActionParameters: json.RawMessage(`{"KMSKeyId":"key"}`)},
+		)
+	})
+	if !errors.Is(err, ErrEncryptionNotSupported) {
+		t.Fatalf("error is %v, want %v", err, ErrEncryptionNotSupported)
+	}
+	if len(response.ProcessedClientActions) != 2 || response.ProcessedClientActions[1].ActionStatus != actionStatusFailed {
+		t.Errorf("unexpected handshake response: %+v", response)
+	}
+}
+
+func TestEncryptionChallengeNotSupported(t *testing.T) {
+	_, _, err := openChannel(t, func(a *agent) {
+		a.handshake(sessionTypeAction(SessionTypeStandard, `{}`))
+		a.send(PayloadTypeEncChallengeRequest, []byte(`{}`))
+	})
+	if !errors.Is(err, ErrEncryptionNotSupported) {
+		t.Fatalf("error is %v, want %v", err, ErrEncryptionNotSupported)
+	}
+}
+
+func TestChannelClosed(t *testing.T) {
+	c, a := openPortChannel(t)
+	a.send(PayloadTypeOutput, []byte("bye"))
+	a.send(PayloadTypeExitCode, []byte("3\n"))
+	payload, _ := json.Marshal(channelClosed{SessionId: "session", Output: "session closed"})
+	a.sendMessage(&Message{MessageType: MessageTypeChannelClosed, SchemaVersion: 1, MessageId: NewUUID(), Payload: payload})
+	output, err := c.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "bye" || c.ExitCode() != 3 || c.CloseOutput() != "session closed" {
+		t.Errorf("output %q, exit code %d, close output %q", output, c.ExitCode(), c.CloseOutput())
+	}
+}
@@ -0,0 +1,154 @@
+package channel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// @see https://github.com/aws/session-manager-plugin/blob/mainline/src/message/clientmessage.go
+
+const (
+	MessageTypeInputStreamData  = "input_stream_data"
+	MessageTypeOutputStreamData = "output_stream_data"
+	MessageTypeAcknowledge      = "acknowledge"
+	MessageTypeChannelClosed    = "channel_closed"
+	MessageTypeStartPublication = "start_publication"
+	MessageTypePausePublication = "pause_publication"
+)
+
+type PayloadType uint32
+
+const (
+	PayloadTypeOutput               PayloadType = 1
+	PayloadTypeError                PayloadType = 2
+	PayloadTypeSize                 PayloadType = 3
+	PayloadTypeParameter            PayloadType = 4
+	PayloadTypeHandshakeRequest     PayloadType = 5
+	PayloadTypeHandshakeResponse    PayloadType = 6
+	PayloadTypeHandshakeComplete    PayloadType = 7
+	PayloadTypeEncChallengeRequest  PayloadType = 8
+	PayloadTypeEncChallengeResponse PayloadType = 9
+	PayloadTypeFlag                 PayloadType = 10
+	PayloadTypeStdErr               PayloadType = 11
+	PayloadTypeExitCode             PayloadType = 12
+)
+
+type PayloadFlag uint32
+
+const (
+	FlagDisconnectToPort   PayloadFlag = 1
+	FlagTerminateSession   PayloadFlag = 2
+	FlagConnectToPortError PayloadFlag = 3
+)
+
+const (
+	MessageFlagData uint64 = 0
+	MessageFlagSyn  uint64 = 1
+	MessageFlagFin  uint64 = 2
+	MessageFlagAck  uint64 = 3
+)
+
+const (
+	messageTypeLength    = 32
+	messageIdLength      = 16
+	payloadDigestLength  = 32
+	headerLengthOffset   = 0
+	messageTypeOffset    = 4
+	schemaVersionOffset  = 36
+	createdDateOffset    = 40
+	sequenceNumberOffset = 48
+	flagsOffset          = 56
+	messageIdOffset      = 64
+	payloadDigestOffset  = 80
+	payloadTypeOffset    = 112
+	payloadLengthOffset  = 116
+	payloadOffset        = 120
+)
+
+var (
+	ErrMessageTooShort = fmt.Errorf("message is too short")
+	ErrPayloadDigest   = fmt.Errorf("payload digest does not match")
+)
+
+type UUID [16]byte
+
+func NewUUID() UUID {
+	id := UUID{}
+	rand.Read(id[:])
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id
+}
+
+func (u UUID) String() string {
+	encoded := hex.EncodeToString(u[:])
+	return encoded[0:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:32]
+}
+
+type Message struct {
+	MessageType    string
+	SchemaVersion  uint32
+	CreatedDate    uint64
+	SequenceNumber int64
+	Flags          uint64
+	MessageId      UUID
+	PayloadType    PayloadType
+	Payload        []byte
+}
+
+// The message id is written with its least significant half first, which is
+// how the agent and session-manager-plugin serialize it.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if len(m.MessageType) > messageTypeLength {
+		return nil, fmt.Errorf("message type %q is too long", m.MessageType)
+	}
+	data := make([]byte, payloadOffset+len(m.Payload))
+	digest := sha256.Sum256(m.Payload)
+	binary.BigEndian.PutUint32(data[headerLengthOffset:], payloadLengthOffset)
+	copy(data[messageTypeOffset:schemaVersionOffset], []byte(m.MessageType+strings.Repeat(" ", messageTypeLength-len(m.MessageType))))
+	binary.BigEndian.PutUint32(data[schemaVersionOffset:], m.SchemaVersion)
+	binary.BigEndian.PutUint64(data[createdDateOffset:], m.CreatedDate)
+	binary.BigEndian.PutUint64(data[sequenceNumberOffset:], uint64(m.SequenceNumber))
+	binary.BigEndian.PutUint64(data[flagsOffset:], m.Flags)
+	copy(data[messageIdOffset:], m.MessageId[8:])
+	copy(data[messageIdOffset+8:], m.MessageId[:8])
+	copy(data[payloadDigestOffset:], digest[:])
+	binary.BigEndian.PutUint32(data[payloadTypeOffset:], uint32(m.PayloadType))
+	binary.BigEndian.PutUint32(data[payloadLengthOffset:], uint32(len(m.Payload)))
+	copy(data[payloadOffset:], m.Payload)
+	return data, nil
+}
+
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if len(data) < payloadOffset {
+		return ErrMessageTooShort
+	}
+	headerLength := binary.BigEndian.Uint32(data[headerLengthOffset:])
+	if int(headerLength)+4 > len(data) {
+		return ErrMessageTooShort
+	}
+	m.MessageType = strings.TrimRight(string(data[messageTypeOffset:schemaVersionOffset]), " \x00")
+	m.SchemaVersion = binary.BigEndian.Uint32(data[schemaVersionOffset:])
+	m.CreatedDate = binary.BigEndian.Uint64(data[createdDateOffset:])
+	m.SequenceNumber = int64(binary.BigEndian.Uint64(data[sequenceNumberOffset:]))
+	m.Flags = binary.BigEndian.Uint64(data[flagsOffset:])
+	copy(m.MessageId[8:], data[messageIdOffset:messageIdOffset+8])
+	copy(m.MessageId[:8], data[messageIdOffset+8:messageIdOffset+messageIdLength])
+	m.PayloadType = PayloadType(binary.BigEndian.Uint32(data[payloadTypeOffset:]))
+	payloadLength := binary.BigEndian.Uint32(data[headerLength:])
+	start := int(headerLength) + 4
+	if start+int(payloadLength) > len(data) {
+		return ErrMessageTooShort
+	}
+	m.Payload = data[start : start+int(payloadLength)]
+	digest := sha256.Sum256(m.Payload)
+	if !bytes.Equal(digest[:], data[payloadDigestOffset:payloadDigestOffset+payloadDigestLength]) {
+		return ErrPayloadDigest
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package channel
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []Message{
+		{MessageType: MessageTypeInputStreamData, SchemaVersion: 1, CreatedDate: 1700000000000, SequenceNumber: 42, Flags: MessageFlagData, MessageId: NewUUID(), PayloadType: PayloadTypeOutput, Payload: []byte("hello")},
+		{MessageType: MessageTypeAcknowledge, SchemaVersion: 1, Flags: MessageFlagAck, MessageId: NewUUID(), Payload: []byte(`{"AcknowledgedMessageSequenceNumber":7}`)},
+		{MessageType: MessageTypeChannelClosed, SchemaVersion: 1, SequenceNumber: -1, MessageId: NewUUID(), Payload: []byte{}},
+		{MessageType: MessageTypeOutputStreamData, SchemaVersion: 1, MessageId: NewUUID(), PayloadType: PayloadTypeFlag, Payload: []byte{0, 0, 0, 1}},
+	}
+	for _, message := range tests {
+		data, err := message.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := Message{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: %v", message.MessageType, err)
+		}
+		if decoded.MessageType != message.MessageType ||
+			decoded.SchemaVersion != message.SchemaVersion ||
+			decoded.CreatedDate != message.CreatedDate ||
+			decoded.SequenceNumber != message.SequenceNumber ||
+			decoded.Flags != message.Flags ||
+			decoded.MessageId != message.MessageId ||
+			decoded.PayloadType != message.PayloadType ||
+			!bytes.Equal(decoded.Payload, message.Payload) {
+			t.Errorf("decoded %+v, want %+v", decoded, message)
+		}
+	}
+}
+
+// The agent writes the least significant half of the message id first.
+func TestMessageIdLayout(t *testing.T) {
+	message := Message{MessageType: MessageTypeAcknowledge, MessageId: UUID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}}
+	data, err := message.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{8, 9, 10, 11, 12, 13, 14, 15, 0, 1, 2, 3, 4, 5, 6, 7}
+	if !bytes.Equal(data[messageIdOffset:messageIdOffset+messageIdLength], expected) {
+		t.Errorf("message id is written as %v", data[messageIdOffset:messageIdOffset+messageIdLength])
+	}
+	if message.MessageId.String() != "00010203-0405-0607-0809-0a0b0c0d0e0f" {
+		t.Errorf("message id string is %s", message.MessageId)
+	}
+}
+
+func TestMessageUnmarshalErrors(t *testing.T) {
+	message := Message{MessageType: MessageTypeOutputStreamData, MessageId: NewUUID(), Payload: []byte("payload")}
+	data, err := message.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Message{}).UnmarshalBinary(data[:payloadOffset-1]); !errors.Is(err, ErrMessageTooShort) {
+		t.Errorf("truncated header: %v", err)
+	}
+	if err := (&Message{}).UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrMessageTooShort) {
+		t.Errorf("truncated payload: %v", err)
+	}
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 0xff
+	if err := (&Message{}).UnmarshalBinary(tampered); !errors.Is(err, ErrPayloadDigest) {
+		t.Errorf("tampered payload: %v", err)
+	}
+	long := Message{MessageType: "a_message_type_that_is_over_32_bytes"}
+	if _, err := long.MarshalBinary(); err == nil {
+		t.Error("expected an error for a message type over 32 bytes")
+	}
+}
@@ -0,0 +1,118 @@
+package channel
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+func (c *Channel) Shell(stdin *os.File, stdout io.Writer) error {
+	fd := int(stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+		resize := func() {
+			if cols, rows, err := term.GetSize(fd); err == nil {
+				c.Resize(cols, rows)
+			}
+		}
+		resize()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGWINCH)
+		defer signal.Stop(signals)
+		go func() {
+			for {
+				select {
+				case <-c.done:
+					return
+				case <-signals:
+					resize()
+				}
+			}
+		}()
+	}
+	go io.Copy(c, stdin)
+	if _, err := io.Copy(stdout, c); err != nil {
+		return err
+	}
+	return c.Err()
+}
+
+func (c *Channel) Output() ([]byte, error) {
+	output, err := io.ReadAll(c)
+	if err != nil {
+		return output, err
+	}
+	return output, c.Err()
+}
+
+func (c *Channel) Pipe(stdin io.Reader, stdout io.Writer) error {
+	go func() {
+		io.Copy(c, stdin)
+		c.Close()
+	}()
+	if _, err := io.Copy(stdout, c); err != nil {
+		return err
+	}
+	return c.Err()
+}
+
+// Port sessions carry a single stream, so connections are served one at a
+// time and the agent reconnects to the remote port when the next one sends.
+func (c *Channel) Forward(listener net.Listener, onConnection func()) error {
+	var current net.Conn
+	mutex := sync.Mutex{}
+	go func() {
+		buffer := make([]byte, 32*1024)
+		for {
+			n, err := c.Read(buffer)
+			if n > 0 {
+				mutex.Lock()
+				if current != nil {
+					current.Write(buffer[:n])
+				}
+				mutex.Unlock()
+			}
+			if err != nil {
+				listener.Close()
+				return
+			}
+		}
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-c.done:
+				return c.err
+			default:
+				return err
+			}
+		}
+		if onConnection != nil {
+			onConnection()
+		}
+		mutex.Lock()
+		current = conn
+		mutex.Unlock()
+		_, err = io.Copy(c, conn)
+		mutex.Lock()
+		current = nil
+		mutex.Unlock()
+		conn.Close()
+		if err != nil {
+			return err
+		}
+		if err = c.SendFlag(FlagDisconnectToPort); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	MaxSendCommandTargets = 50
+	MinDeliveryTimeout    = 30
+	CommandStatusPending  = "Pending"
+)
+
+type CommandInvocation struct {
+	CommandId     string    `json:"commandId"`
+	InstanceId    string    `json:"instanceId"`
+	Region        string    `json:"region"`
+	Status        string    `json:"status"`
+	StatusDetails string    `json:"statusDetails"`
+	ExitCode      int32     `json:"exitCode"`
+	Stdout        string    `json:"stdout"`
+	Stderr        string    `json:"stderr"`
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+}
+
+func (i *CommandInvocation) IsDone() bool {
+	switch ssmtypes.CommandInvocationStatus(i.Status) {
+	case ssmtypes.CommandInvocationStatusSuccess,
+		ssmtypes.CommandInvocationStatusCancelled,
+		ssmtypes.CommandInvocationStatusTimedOut,
+		ssmtypes.CommandInvocationStatusFailed:
+		return true
+	}
+	return false
+}
+
+func (i *CommandInvocation) IsSuccess() bool {
+	return i.Status == string(ssmtypes.CommandInvocationStatusSuccess)
+}
+
+// Errors the SDK would have retried are still transient once its own attempts
+// run out, like throttling while many invocations are polled at once.
+func IsTransientError(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary ||
+		retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// The timeout applies to delivery as well as to execution, so instances that
+// are offline time out instead of staying pending for the default hour.
+func (r *Role) SendShellCommand(region string, instanceIds []string, commands []string, timeoutSeconds int32, comment string) (string, error) {
+	client := r.ssmClient(region)
+	input := ssm.SendCommandInput{
+		DocumentName:   aws.String("AWS-RunShellScript"),
+		InstanceIds:    instanceIds,
+		TimeoutSeconds: aws.Int32(max(timeoutSeconds, MinDeliveryTimeout)),
+		Parameters: map[string][]string{
+			"commands":         commands,
+			"executionTimeout": {fmt.Sprintf("%d", timeoutSeconds)},
+		},
+	}
+	if comment != "" {
+		input.Comment = aws.String(comment)
+	}
+	output, err := client.SendCommand(context.TODO(), &input)
+	if err != nil {
+		return "", err
+	}
+	return *output.Command.CommandId, nil
+}
+
+// Invocations are created asynchronously after SendCommand returns, so one
+// that does not exist yet is reported as pending rather than as an error.
+func (r *Role) GetCommandInvocation(region, commandId, instanceId string) (*CommandInvocation, error) {
+	client := r.ssmClient(region)
+	invocation := &CommandInvocation{CommandId: commandId, InstanceId: instanceId, Region: region, Status: CommandStatusPending, ExitCode: -1}
+	output, err := client.GetCommandInvocation(context.TODO(), &ssm.GetCommandInvocationInput{
+		CommandId:  &commandId,
+		InstanceId: &instanceId,
+	})
+	if err != nil {
+		var notFound *ssmtypes.InvocationDoesNotExist
+		if errors.As(err, &notFound) {
+			return invocation, nil
+		}
+		return nil, err
+	}
+	invocation.Status = string(output.Status)
+	invocation.StatusDetails = aws.ToString(output.StatusDetails)
+	invocation.ExitCode = output.ResponseCode
+	invocation.Stdout = aws.ToString(output.StandardOutputContent)
+	invocation.Stderr = aws.ToString(output.StandardErrorContent)
+	if startedAt, err := time.Parse(time.RFC3339, aws.ToString(output.ExecutionStartDateTime)); err == nil {
+		invocation.StartedAt = startedAt
+	}
+	if finishedAt, err := time.Parse(time.RFC3339, aws.ToString(output.ExecutionEndDateTime)); err == nil {
+		invocation.FinishedAt = finishedAt
+	}
+	return invocation, nil
+}
@@ -0,0 +1,72 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	InstancesCachePath = ".aws/knox/instances"
+)
+
+type cachedInstances struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Instances Instances `json:"instances"`
+}
+
+func (r *Role) instancesCacheFile(region string, filters InstanceFilters) (string, error) {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	serializedFilters, err := json.Marshal(filters)
+	if err != nil {
+		return "", err
+	}
+	key := region + "_" + fileSafeKey(string(serializedFilters))
+	return filepath.Join(homedir, InstancesCachePath, r.AccountId, key+".json"), nil
+}
+
+func (r *Role) GetCachedInstances(region string, filters InstanceFilters) (Instances, time.Time, error) {
+	cachePath, err := r.instancesCacheFile(region, filters)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	contents, err := os.ReadFile(cachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	cached := cachedInstances{}
+	if err := json.Unmarshal(contents, &cached); err != nil {
+		return nil, time.Time{}, err
+	}
+	return cached.Instances, cached.FetchedAt, nil
+}
+
+func (r *Role) SaveCachedInstances(region string, filters InstanceFilters, instances Instances) error {
+	cachePath, err := r.instancesCacheFile(region, filters)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(cachedInstances{FetchedAt: time.Now(), Instances: instances})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cachePath, contents, 0600)
+}
+
+func DeleteCachedInstances() error {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(homedir, InstancesCachePath))
+}
@@ -0,0 +1,328 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	PingStatusUnknown    = "-"
+	PingStatusNotManaged = "NotManaged"
+)
+
+var (
+	ErrInvalidInstanceFilter = fmt.Errorf("instance filter must be in the form name=value[,value]")
+	ErrInstanceInformation   = fmt.Errorf("failed to describe instance information, ping status is unknown and hybrid nodes are not listed")
+	clientSideFilterNames    = []string{"ping-status", "platform", "agent-version", "region"}
+)
+
+type InstanceFilters []InstanceFilter
+
+type InstanceFilter struct {
+	Name   string
+	Values []string
+}
+
+func ParseInstanceFilter(expression string) (InstanceFilter, error) {
+	name, values, found := strings.Cut(expression, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.TrimSpace(values) == "" {
+		return InstanceFilter{}, ErrInvalidInstanceFilter
+	}
+	filter := InstanceFilter{Name: name}
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			filter.Values = append(filter.Values, value)
+		}
+	}
+	return filter, nil
+}
+
+func ParseInstanceFilters(expressions []string) (InstanceFilters, error) {
+	filters := InstanceFilters{}
+	for _, expression := range expressions {
+		filter, err := ParseInstanceFilter(expression)
+		if err != nil {
+			return filters, fmt.Errorf("%w: %q", err, expression)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func (f *InstanceFilter) isClientSide() bool {
+	return slices.Contains(clientSideFilterNames, f.Name)
+}
+
+func (f *InstanceFilter) resolve(instance *Instance) (string, bool) {
+	switch {
+	case f.Name == "instance-id":
+		return instance.Id, true
+	case f.Name == "instance-type":
+		return instance.InstanceType, true
+	case f.Name == "private-ip-address":
+		return instance.PrivateIpAddress, true
+	case f.Name == "ip-address":
+		return instance.PublicIpAddress, true
+	case f.Name == "ping-status":
+		return instance.PingStatus, true
+	case f.Name == "platform":
+		return instance.PlatformName, true
+	case f.Name == "agent-version":
+		return instance.AgentVersion, true
+	case f.Name == "region":
+		return instance.Region, true
+	case strings.HasPrefix(f.Name, "tag:"):
+		value, ok := instance.Tags[strings.TrimPrefix(f.Name, "tag:")]
+		return value, ok
+	}
+	return "", false
+}
+
+func (f *InstanceFilter) Matches(instance *Instance) bool {
+	value, ok := f.resolve(instance)
+	if !ok {
+		return false
+	}
+	for _, pattern := range f.Values {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (f InstanceFilters) ec2Filters() []ec2types.Filter {
+	filters := []ec2types.Filter{}
+	hasState := false
+	for _, filter := range f {
+		if filter.isClientSide() {
+			continue
+		}
+		if filter.Name == "instance-state-name" {
+			hasState = true
+		}
+		filters = append(filters, ec2types.Filter{Name: aws.String(filter.Name), Values: filter.Values})
+	}
+	if !hasState {
+		filters = append(filters, ec2types.Filter{Name: aws.String("instance-state-name"), Values: []string{"running"}})
+	}
+	return filters
+}
+
+func (f InstanceFilters) matches(instance *Instance, clientSideOnly bool) bool {
+	for _, filter := range f {
+		if clientSideOnly && !filter.isClientSide() {
+			continue
+		}
+		if filter.Name == "instance-state-name" && instance.IsHybrid() {
+			continue
+		}
+		if !filter.Matches(instance) {
+			return false
+		}
+	}
+	return true
+}
+
+type Instances []Instance
+
+type Instance struct {
+	Id               string            `json:"id"`
+	Region           string            `json:"region"`
+	InstanceType     string            `json:"instanceType"`
+	PrivateIpAddress string            `json:"privateIpAddress"`
+	PublicIpAddress  string            `json:"publicIpAddress"`
+	Tags             map[string]string `json:"tags"`
+	PingStatus       string            `json:"pingStatus"`
+	PlatformName     string            `json:"platformName"`
+	AgentVersion     string            `json:"agentVersion"`
+	LastPingDateTime time.Time         `json:"lastPingDateTime"`
+	LaunchTime       time.Time         `json:"launchTime"`
+	AvailabilityZone string            `json:"availabilityZone"`
+	VpcId            string            `json:"vpcId"`
+	SubnetId         string            `json:"subnetId"`
+	InstanceProfile  string            `json:"instanceProfile"`
+}
+
+func (i *Instance) IsHybrid() bool {
+	return strings.HasPrefix(i.Id, "mi-")
+}
+
+func (i *Instance) IsOffline() bool {
+	return i.PingStatus != PingStatusUnknown && i.PingStatus != string(ssmtypes.PingStatusOnline)
+}
+
+func (i Instances) FindById(id string) *Instance {
+	for _, instance := range i {
+		if instance.Id == id {
+			return &instance
+		}
+	}
+	return nil
+}
+
+func (r *Role) getInstanceInformation(region string) (map[string]ssmtypes.InstanceInformation, error) {
+	information := map[string]ssmtypes.InstanceInformation{}
+	client := r.ssmClient(region)
+	paginator := ssm.NewDescribeInstanceInformationPaginator(client, &ssm.DescribeInstanceInformationInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return information, err
+		}
+		for _, info := range page.InstanceInformationList {
+			information[aws.ToString(info.InstanceId)] = info
+		}
+	}
+	return information, nil
+}
+
+func (i *Instance) mergeInstanceInformation(info ssmtypes.InstanceInformation) {
+	i.PingStatus = string(info.PingStatus)
+	i.PlatformName = strings.TrimSpace(aws.ToString(info.PlatformName) + " " + aws.ToString(info.PlatformVersion))
+	i.AgentVersion = aws.ToString(info.AgentVersion)
+	i.LastPingDateTime = aws.ToTime(info.LastPingDateTime)
+	if i.PlatformName == "" {
+		i.PlatformName = string(info.PlatformType)
+	}
+}
+
+func hybridInstance(region string, info ssmtypes.InstanceInformation) Instance {
+	privateIp := "-"
+	if info.IPAddress != nil {
+		privateIp = aws.ToString(info.IPAddress)
+	}
+	name := aws.ToString(info.Name)
+	if name == "" {
+		name = aws.ToString(info.ComputerName)
+	}
+	instance := Instance{
+		Id:               aws.ToString(info.InstanceId),
+		Region:           region,
+		InstanceType:     "-",
+		PrivateIpAddress: privateIp,
+		PublicIpAddress:  "-",
+		Tags:             map[string]string{"Name": name},
+	}
+	instance.mergeInstanceInformation(info)
+	return instance
+}
+
+func (r *Role) GetManagedInstances(region string, filters InstanceFilters) (Instances, error) {
+	instances := Instances{}
+	err := r.GetManagedInstancesStream(region, filters, func(page Instances) {
+		instances = append(instances, page...)
+	})
+	return instances, err
+}
+
+// EC2 instances are still listed when instance information can't be
+// described, like without ssm:DescribeInstanceInformation, and the error that
+// is returned afterwards wraps ErrInstanceInformation.
+func (r *Role) GetManagedInstancesStream(region string, filters InstanceFilters, onInstances func(Instances)) error {
+	if r.Credentials == nil {
+		return ErrorRoleCredentialsNil
+	}
+	information, informationErr := r.getInstanceInformation(region)
+	if informationErr != nil {
+		information = nil
+		informationErr = fmt.Errorf("%w: %w", ErrInstanceInformation, informationErr)
+	}
+	client := r.ec2Client(region)
+	params := ec2.DescribeInstancesInput{Filters: filters.ec2Filters()}
+	paginator := ec2.NewDescribeInstancesPaginator(client, &params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		instances := Instances{}
+		for _, info := range page.Reservations {
+			for _, instance := range info.Instances {
+				privateId := "-"
+				publicId := "-"
+				if instance.PrivateIpAddress != nil {
+					privateId = aws.ToString(instance.PrivateIpAddress)
+				}
+				if instance.PublicIpAddress != nil {
+					publicId = aws.ToString(instance.PublicIpAddress)
+				}
+				tags := map[string]string{}
+				for _, tag := range instance.Tags {
+					key := aws.ToString(tag.Key)
+					value := aws.ToString(tag.Value)
+					tags[key] = value
+				}
+				availabilityZone := ""
+				if instance.Placement != nil {
+					availabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+				}
+				instanceProfile := ""
+				if instance.IamInstanceProfile != nil {
+					instanceProfile = aws.ToString(instance.IamInstanceProfile.Arn)
+				}
+				instance := Instance{
+					Id:               aws.ToString(instance.InstanceId),
+					Region:           region,
+					InstanceType:     string(instance.InstanceType),
+					PrivateIpAddress: privateId,
+					PublicIpAddress:  publicId,
+					Tags:             tags,
+					PingStatus:       PingStatusUnknown,
+					PlatformName:     aws.ToString(instance.PlatformDetails),
+					AgentVersion:     "-",
+					LaunchTime:       aws.ToTime(instance.LaunchTime),
+					VpcId:            aws.ToString(instance.VpcId),
+					SubnetId:         aws.ToString(instance.SubnetId),
+					AvailabilityZone: availabilityZone,
+					InstanceProfile:  instanceProfile,
+				}
+				if instance.PlatformName == "" {
+					instance.PlatformName = "-"
+				}
+				if information != nil {
+					instance.PingStatus = PingStatusNotManaged
+					if details, ok := information[instance.Id]; ok {
+						instance.mergeInstanceInformation(details)
+					}
+				}
+				if filters.matches(&instance, true) {
+					instances = append(instances, instance)
+				}
+			}
+		}
+		if len(instances) > 0 && onInstances != nil {
+			onInstances(instances)
+		}
+	}
+	hybridIds := []string{}
+	for id := range information {
+		if strings.HasPrefix(id, "mi-") {
+			hybridIds = append(hybridIds, id)
+		}
+	}
+	sort.Strings(hybridIds)
+	hybridInstances := Instances{}
+	for _, id := range hybridIds {
+		instance := hybridInstance(region, information[id])
+		if filters.matches(&instance, false) {
+			hybridInstances = append(hybridInstances, instance)
+		}
+	}
+	if len(hybridInstances) > 0 && onInstances != nil {
+		onInstances(hybridInstances)
+	}
+	return informationErr
+}
@@ -0,0 +1,151 @@
+package credentials
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseInstanceFilter(t *testing.T) {
+	tests := []struct {
+		expression string
+		filter     InstanceFilter
+		err        error
+	}{
+		{"tag:Name=web-*", InstanceFilter{Name: "tag:Name", Values: []string{"web-*"}}, nil},
+		{" platform = Ubuntu , Amazon Linux ", InstanceFilter{Name: "platform", Values: []string{"Ubuntu", "Amazon Linux"}}, nil},
+		{"tag:Env=prod,,staging,", InstanceFilter{Name: "tag:Env", Values: []string{"prod", "staging"}}, nil},
+		{"tag:Query=a=b", InstanceFilter{Name: "tag:Query", Values: []string{"a=b"}}, nil},
+		{"tag:Name", InstanceFilter{}, ErrInvalidInstanceFilter},
+		{"=web", InstanceFilter{}, ErrInvalidInstanceFilter},
+		{"tag:Name= ", InstanceFilter{}, ErrInvalidInstanceFilter},
+		{"", InstanceFilter{}, ErrInvalidInstanceFilter},
+	}
+	for _, test := range tests {
+		filter, err := ParseInstanceFilter(test.expression)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q: error is %v, want %v", test.expression, err, test.err)
+		}
+		if !reflect.DeepEqual(filter, test.filter) {
+			t.Errorf("%q: filter is %+v, want %+v", test.expression, filter, test.filter)
+		}
+	}
+}
+
+func TestParseInstanceFilters(t *testing.T) {
+	filters, err := ParseInstanceFilters([]string{"tag:Env=prod", "region=us-*"})
+	if err != nil || len(filters) != 2 {
+		t.Fatalf("filters %+v, error %v", filters, err)
+	}
+	if _, err := ParseInstanceFilters([]string{"tag:Env=prod", "broken"}); !errors.Is(err, ErrInvalidInstanceFilter) {
+		t.Errorf("error is %v, want %v", err, ErrInvalidInstanceFilter)
+	}
+}
+
+func TestInstanceFilterMatches(t *testing.T) {
+	instance := &Instance{
+		Id:               "i-0123456789abcdef0",
+		InstanceType:     "t3.micro",
+		PrivateIpAddress: "10.0.1.20",
+		PingStatus:       "Online",
+		PlatformName:     "Ubuntu",
+		AgentVersion:     "3.3.40.0",
+		Region:           "us-east-1",
+		Tags:             map[string]string{"Name": "web-1", "Env": "prod", "Empty": ""},
+	}
+	tests := []struct {
+		filter  string
+		matches bool
+	}{
+		{"tag:Name=web-1", true},
+		{"tag:Name=web-*", true},
+		{"tag:Name=web-?", true},
+		{"tag:Name=web-[0-9]", true},
+		{"tag:Name=api-*,web-*", true},
+		{"tag:Name=web", false},
+		{"tag:Name=Web-1", false},
+		{"tag:Missing=*", false},
+		{"tag:Empty=*", true},
+		{"tag:Name=[", false},
+		{"instance-id=i-0123*", true},
+		{"instance-type=t3.*", true},
+		{"private-ip-address=10.0.1.*", true},
+		{"ip-address=*", true},
+		{"ping-status=Online", true},
+		{"ping-status=ConnectionLost", false},
+		{"platform=Ubuntu", true},
+		{"agent-version=3.*", true},
+		{"region=us-*", true},
+		{"region=eu-*", false},
+		{"vpc-id=*", false},
+	}
+	for _, test := range tests {
+		filter, err := ParseInstanceFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := filter.Matches(instance); matches != test.matches {
+			t.Errorf("%q: matches is %t, want %t", test.filter, matches, test.matches)
+		}
+	}
+}
+
+// EC2 instances are filtered by EC2 first, so only the client side filters
+// are matched against them, while hybrid nodes are matched against every
+// filter except the instance state, which they don't have.
+func TestInstanceFiltersMatches(t *testing.T) {
+	ec2Instance := &Instance{Id: "i-0123456789abcdef0", PingStatus: "Online", Region: "us-east-1", Tags: map[string]string{"Env": "prod"}}
+	hybridInstance := &Instance{Id: "mi-0123456789abcdef0", PingStatus: "Online", Region: "us-east-1", Tags: map[string]string{"Env": "prod"}}
+	tests := []struct {
+		filters []string
+		ec2     bool
+		hybrid  bool
+	}{
+		{[]string{}, true, true},
+		{[]string{"tag:Env=prod", "ping-status=Online"}, true, true},
+		{[]string{"tag:Env=staging"}, true, false},
+		{[]string{"ping-status=ConnectionLost"}, false, false},
+		{[]string{"instance-state-name=running"}, true, true},
+		{[]string{"instance-state-name=stopped", "region=us-*"}, true, true},
+		{[]string{"vpc-id=vpc-123"}, true, false},
+		{[]string{"tag:Env=prod", "region=eu-*"}, false, false},
+	}
+	for _, test := range tests {
+		filters, err := ParseInstanceFilters(test.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := filters.matches(ec2Instance, true); matches != test.ec2 {
+			t.Errorf("%v: ec2 instance matches is %t, want %t", test.filters, matches, test.ec2)
+		}
+		if matches := filters.matches(hybridInstance, false); matches != test.hybrid {
+			t.Errorf("%v: hybrid instance matches is %t, want %t", test.filters, matches, test.hybrid)
+		}
+	}
+}
+
+func TestInstanceFiltersEC2Filters(t *testing.T) {
+	tests := []struct {
+		filters []string
+		ec2     map[string][]string
+	}{
+		{[]string{}, map[string][]string{"instance-state-name": {"running"}}},
+		{[]string{"tag:Env=prod", "ping-status=Online", "platform=Ubuntu", "agent-version=3.*", "region=us-*"}, map[string][]string{"tag:Env": {"prod"}, "instance-state-name": {"running"}}},
+		{[]string{"instance-state-name=running,stopped"}, map[string][]string{"instance-state-name": {"running", "stopped"}}},
+	}
+	for _, test := range tests {
+		filters, err := ParseInstanceFilters(test.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ec2 := map[string][]string{}
+		for _, filter := range filters.ec2Filters() {
+			ec2[aws.ToString(filter.Name)] = filter.Values
+		}
+		if !reflect.DeepEqual(ec2, test.ec2) {
+			t.Errorf("%v: ec2 filters are %v, want %v", test.filters, ec2, test.ec2)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+const (
+	RegionsCachePath = ".aws/knox/regions"
+	RegionsCacheTTL  = 24 * time.Hour
+)
+
+const (
+	OptInStatusNotRequired = "opt-in-not-required"
+	OptInStatusOptedIn     = "opted-in"
+	OptInStatusNotOptedIn  = "not-opted-in"
+	OptInStatusUnknown     = "unknown"
+)
+
+type Regions []Region
+
+type Region struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	OptInStatus string `json:"optInStatus"`
+}
+
+type cachedRegions struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Regions   Regions   `json:"regions"`
+}
+
+var KnownRegions = Regions{
+	{"us-east-1", "US East (N. Virginia)", OptInStatusUnknown},
+	{"us-east-2", "US East (Ohio)", OptInStatusUnknown},
+	{"us-west-1", "US West (N. California)", OptInStatusUnknown},
+	{"us-west-2", "US West (Oregon)", OptInStatusUnknown},
+	{"af-south-1", "Africa (Cape Town)", OptInStatusUnknown},
+	{"ap-east-1", "Asia Pacific (Hong Kong)", OptInStatusUnknown},
+	{"ap-south-1", "Asia Pacific (Mumbai)", OptInStatusUnknown},
+	{"ap-south-2", "Asia Pacific (Hyderabad)", OptInStatusUnknown},
+	{"ap-southeast-1", "Asia Pacific (Singapore)", OptInStatusUnknown},
+	{"ap-southeast-2", "Asia Pacific (Sydney)", OptInStatusUnknown},
+	{"ap-southeast-3", "Asia Pacific (Jakarta)", OptInStatusUnknown},
+	{"ap-southeast-4", "Asia Pacific (Melbourne)", OptInStatusUnknown},
+	{"ap-southeast-5", "Asia Pacific (Malaysia)", OptInStatusUnknown},
+	{"ap-northeast-1", "Asia Pacific (Tokyo)", OptInStatusUnknown},
+	{"ap-northeast-2", "Asia Pacific (Seoul)", OptInStatusUnknown},
+	{"ap-northeast-3", "Asia Pacific (Osaka)", OptInStatusUnknown},
+	{"ca-central-1", "Canada (Central)", OptInStatusUnknown},
+	{"ca-west-1", "Canada West (Calgary)", OptInStatusUnknown},
+	{"eu-central-1", "EU (Frankfurt)", OptInStatusUnknown},
+	{"eu-central-2", "EU (Zurich)", OptInStatusUnknown},
+	{"eu-west-1", "EU (Ireland)", OptInStatusUnknown},
+	{"eu-west-2", "EU (London)", OptInStatusUnknown},
+	{"eu-west-3", "EU (Paris)", OptInStatusUnknown},
+	{"eu-south-1", "EU (Milan)", OptInStatusUnknown},
+	{"eu-south-2", "EU (Spain)", OptInStatusUnknown},
+	{"eu-north-1", "EU (Stockholm)", OptInStatusUnknown},
+	{"il-central-1", "Israel (Tel Aviv)", OptInStatusUnknown},
+	{"me-south-1", "Middle East (Bahrain)", OptInStatusUnknown},
+	{"me-central-1", "Middle East (UAE)", OptInStatusUnknown},
+	{"sa-east-1", "South America (Sao Paulo)", OptInStatusUnknown},
+	{"us-gov-west-1", "AWS GovCloud (US-West)", OptInStatusUnknown},
+	{"us-gov-east-1", "AWS GovCloud (US-East)", OptInStatusUnknown},
+	{"cn-north-1", "China (Beijing)", OptInStatusUnknown},
+	{"cn-northwest-1", "China (Ningxia)", OptInStatusUnknown},
+}
+
+func (r Regions) FindByName(name string) *Region {
+	for _, region := range r {
+		if region.Name == name {
+			return &region
+		}
+	}
+	return nil
+}
+
+func (r Regions) Enabled() Regions {
+	enabled := Regions{}
+	for _, region := range r {
+		if region.IsEnabled() {
+			enabled = append(enabled, region)
+		}
+	}
+	return enabled
+}
+
+func (r *Region) IsEnabled() bool {
+	return r.OptInStatus == OptInStatusNotRequired || r.OptInStatus == OptInStatusOptedIn
+}
+
+func regionsCacheFile(accountId string) (string, error) {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homedir, RegionsCachePath, accountId+".json"), nil
+}
+
+func findRegions(accountId string) (Regions, error) {
+	cachePath, err := regionsCacheFile(accountId)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := os.ReadFile(cachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cached := cachedRegions{}
+	if err := json.Unmarshal(contents, &cached); err != nil {
+		return nil, err
+	}
+	if time.Since(cached.FetchedAt) > RegionsCacheTTL {
+		return nil, nil
+	}
+	return cached.Regions, nil
+}
+
+func saveRegions(accountId string, regions Regions) error {
+	cachePath, err := regionsCacheFile(accountId)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(cachedRegions{FetchedAt: time.Now(), Regions: regions})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cachePath, contents, 0600)
+}
+
+func (r *Role) GetRegions(region string) (Regions, error) {
+	if r.Credentials == nil {
+		return nil, ErrorRoleCredentialsNil
+	}
+	if cached, err := findRegions(r.AccountId); err == nil && cached != nil {
+		return cached, nil
+	}
+	client := r.ec2Client(region)
+	output, err := client.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{AllRegions: aws.Bool(true)})
+	if err != nil {
+		return nil, err
+	}
+	regions := Regions{}
+	for _, details := range output.Regions {
+		name := aws.ToString(details.RegionName)
+		description := "-"
+		if known := KnownRegions.FindByName(name); known != nil {
+			description = known.Description
+		}
+		regions = append(regions, Region{
+			Name:        name,
+			Description: description,
+			OptInStatus: aws.ToString(details.OptInStatus),
+		})
+	}
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].Name < regions[j].Name
+	})
+	if err := saveRegions(r.AccountId, regions); err != nil {
+		return regions, err
+	}
+	return regions, nil
+}
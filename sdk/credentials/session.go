@@ -13,7 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awscredentials "github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/aws/aws-sdk-go-v2/service/sso"
 	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
@@ -25,64 +25,76 @@ var (
 	ErrRoleNil              = fmt.Errorf("role cannot be nil")
 )
 
-type Instances []Instance
-
-type Instance struct {
-	Id               string
-	InstanceType     string
-	PrivateIpAddress string
-	PublicIpAddress  string
-	Tags             map[string]string
-}
-
-func (r *Role) StartSession(instanceId string, defaultUid uint32) (*ssm.StartSessionOutput, error) {
-	staticProvider := awscredentials.NewStaticCredentialsProvider(
+func (r *Role) credentialsProvider() awscredentials.StaticCredentialsProvider {
+	return awscredentials.NewStaticCredentialsProvider(
 		r.Credentials.AccessKeyId,
 		r.Credentials.SecretAccessKey,
 		r.Credentials.SessionToken,
 	)
-	options := ssm.Options{Region: "us-east-1", Credentials: staticProvider}
-	client := ssm.New(options)
+}
+
+func (r *Role) ssmClient(region string) *ssm.Client {
+	options := ssm.Options{Region: region, Credentials: r.credentialsProvider()}
+	return ssm.New(options)
+}
+
+func (r *Role) ec2Client(region string) *ec2.Client {
+	options := ec2.Options{Region: region, Credentials: r.credentialsProvider()}
+	return ec2.New(options)
+}
+
+func (r *Role) StartSession(region, instanceId string, defaultUid uint32) (*ssm.StartSessionOutput, error) {
+	return r.StartSessionDocument(region, instanceId, "AWS-StartInteractiveCommand", map[string][]string{
+		"command": {fmt.Sprintf("sudo su - `id -un %d`", defaultUid)},
+	})
+}
+
+// An empty document name starts the account's default shell session, which
+// follows its Session Manager preferences.
+func (r *Role) StartSessionDocument(region, instanceId, document string, parameters map[string][]string) (*ssm.StartSessionOutput, error) {
+	client := r.ssmClient(region)
+	input := ssm.StartSessionInput{
+		Target:     &instanceId,
+		Parameters: parameters,
+	}
+	if document != "" {
+		input.DocumentName = aws.String(document)
+	}
+	return client.StartSession(context.TODO(), &input)
+}
+
+func (r *Role) StartCommand(region, instanceId string, command string) (*ssm.StartSessionOutput, error) {
+	client := r.ssmClient(region)
 	input := ssm.StartSessionInput{
 		Target:       &instanceId,
 		DocumentName: aws.String("AWS-StartInteractiveCommand"),
 		Parameters: map[string][]string{
-			"command": {fmt.Sprintf("sudo su - `id -un %d`", defaultUid)},
+			"command": {fmt.Sprintf("sudo -u root bash -c %q", command)},
 		},
 	}
 	return client.StartSession(context.TODO(), &input)
 }
 
-func (r *Role) StartCommand(instanceId string, command string) (*ssm.StartSessionOutput, error) {
-	staticProvider := awscredentials.NewStaticCredentialsProvider(
-		r.Credentials.AccessKeyId,
-		r.Credentials.SecretAccessKey,
-		r.Credentials.SessionToken,
-	)
-	options := ssm.Options{Region: "us-east-1", Credentials: staticProvider}
-	client := ssm.New(options)
+func (r *Role) StartPortForward(region, instanceId string, port, localPort uint16) (*ssm.StartSessionOutput, error) {
+	client := r.ssmClient(region)
 	input := ssm.StartSessionInput{
 		Target:       &instanceId,
-		DocumentName: aws.String("AWS-StartInteractiveCommand"),
+		DocumentName: aws.String("AWS-StartPortForwardingSession"),
 		Parameters: map[string][]string{
-			"command": {fmt.Sprintf("sudo -u root bash -c %q", command)},
+			"portNumber":      []string{fmt.Sprintf("%d", port)},
+			"localPortNumber": []string{fmt.Sprintf("%d", localPort)},
 		},
 	}
 	return client.StartSession(context.TODO(), &input)
 }
 
-func (r *Role) StartPortForward(instanceId string, port, localPort uint16) (*ssm.StartSessionOutput, error) {
-	staticProvider := awscredentials.NewStaticCredentialsProvider(
-		r.Credentials.AccessKeyId,
-		r.Credentials.SecretAccessKey,
-		r.Credentials.SessionToken,
-	)
-	options := ssm.Options{Region: "us-east-1", Credentials: staticProvider}
-	client := ssm.New(options)
+func (r *Role) StartPortForwardToRemoteHost(region, instanceId, host string, port, localPort uint16) (*ssm.StartSessionOutput, error) {
+	client := r.ssmClient(region)
 	input := ssm.StartSessionInput{
 		Target:       &instanceId,
-		DocumentName: aws.String("AWS-StartPortForwardingSession"),
+		DocumentName: aws.String("AWS-StartPortForwardingSessionToRemoteHost"),
 		Parameters: map[string][]string{
+			"host":            []string{host},
 			"portNumber":      []string{fmt.Sprintf("%d", port)},
 			"localPortNumber": []string{fmt.Sprintf("%d", localPort)},
 		},
@@ -90,6 +102,36 @@ func (r *Role) StartPortForward(instanceId string, port, localPort uint16) (*ssm
 	return client.StartSession(context.TODO(), &input)
 }
 
+func (r *Role) StartSSHSession(region, instanceId string, port uint16) (*ssm.StartSessionOutput, error) {
+	client := r.ssmClient(region)
+	input := ssm.StartSessionInput{
+		Target:       &instanceId,
+		DocumentName: aws.String("AWS-StartSSHSession"),
+		Parameters: map[string][]string{
+			"portNumber": []string{fmt.Sprintf("%d", port)},
+		},
+	}
+	return client.StartSession(context.TODO(), &input)
+}
+
+func (r *Role) TerminateSession(ctx context.Context, region, sessionId string) error {
+	client := r.ssmClient(region)
+	_, err := client.TerminateSession(ctx, &ssm.TerminateSessionInput{SessionId: &sessionId})
+	return err
+}
+
+func (r *Role) SendSSHPublicKey(region, instanceId, osUser, publicKey string) error {
+	options := ec2instanceconnect.Options{Region: region, Credentials: r.credentialsProvider()}
+	client := ec2instanceconnect.New(options)
+	input := ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     &instanceId,
+		InstanceOSUser: &osUser,
+		SSHPublicKey:   &publicKey,
+	}
+	_, err := client.SendSSHPublicKey(context.TODO(), &input)
+	return err
+}
+
 type Roles []Role
 
 type Role struct {
@@ -113,62 +155,6 @@ func (r Roles) FindByName(name string) *Role {
 	return nil
 }
 
-func (r *Role) GetManagedInstances(region string) (Instances, error) {
-	instances := Instances{}
-	if r.Credentials == nil {
-		return instances, ErrorRoleCredentialsNil
-	}
-	staticProvider := awscredentials.NewStaticCredentialsProvider(
-		r.Credentials.AccessKeyId,
-		r.Credentials.SecretAccessKey,
-		r.Credentials.SessionToken,
-	)
-	options := ec2.Options{Region: region, Credentials: staticProvider}
-	client := ec2.New(options)
-	params := ec2.DescribeInstancesInput{
-		Filters: []ec2types.Filter{
-			{
-				Name:   aws.String("instance-state-name"),
-				Values: []string{"running"},
-			},
-		},
-	}
-	paginator := ec2.NewDescribeInstancesPaginator(client, &params)
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			return instances, err
-		}
-		for _, info := range page.Reservations {
-			for _, instance := range info.Instances {
-				privateId := "-"
-				publicId := "-"
-				if instance.PrivateIpAddress != nil {
-					privateId = aws.ToString(instance.PrivateIpAddress)
-				}
-				if instance.PublicIpAddress != nil {
-					publicId = aws.ToString(instance.PublicIpAddress)
-				}
-				tags := map[string]string{}
-				for _, tag := range instance.Tags {
-					key := aws.ToString(tag.Key)
-					value := aws.ToString(tag.Value)
-					tags[key] = value
-				}
-				instance := Instance{
-					Id:               aws.ToString(instance.InstanceId),
-					InstanceType:     string(instance.InstanceType),
-					PrivateIpAddress: privateId,
-					PublicIpAddress:  publicId,
-					Tags:             tags,
-				}
-				instances = append(instances, instance)
-			}
-		}
-	}
-	return instances, nil
-}
-
 type Accounts []Account
 
 type Account struct {
@@ -0,0 +1,357 @@
+package picker
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"atomicgo.dev/keyboard/keys"
+	"github.com/containerd/console"
+)
+
+const (
+	mouseLeft      = 0
+	mouseWheelUp   = 64
+	mouseWheelDown = 65
+	mouseMotion    = 32
+	escapeTimeout  = 50 * time.Millisecond
+)
+
+type MouseEvent struct {
+	Button  int
+	X       int
+	Y       int
+	Release bool
+}
+
+// Events are either a key, a mouse report, or the answer to a cursor
+// position query, which arrives on the same stream as the keys.
+type Event struct {
+	Key       keys.Key
+	Mouse     *MouseEvent
+	CursorRow int
+}
+
+type EventSource interface {
+	Next() ([]Event, error)
+}
+
+// DefaultInput is used by pickers without an input of their own, sources
+// that are also an io.Closer are closed once the picker is done.
+var DefaultInput = func() (EventSource, error) {
+	return openTerminalInput()
+}
+
+type readerInput struct {
+	reader  io.Reader
+	chunks  chan []byte
+	err     error
+	pending []byte
+	once    sync.Once
+	stopped chan struct{}
+}
+
+// NewEventSource decodes keys and mouse reports from raw terminal input, like
+// the input side of a vterm.Terminal. The reader is read from in the
+// background until it fails, so one source should be kept per reader.
+func NewEventSource(reader io.Reader) EventSource {
+	return newReaderInput(reader)
+}
+
+func newReaderInput(reader io.Reader) *readerInput {
+	return &readerInput{reader: reader, chunks: make(chan []byte), stopped: make(chan struct{})}
+}
+
+// Stopping lets the background read finish without waiting for another
+// Next, once the reader is closed.
+func (r *readerInput) stop() {
+	close(r.stopped)
+}
+
+func (r *readerInput) read() {
+	defer close(r.chunks)
+	for {
+		buf := make([]byte, 1024)
+		n, err := r.reader.Read(buf)
+		if n > 0 {
+			select {
+			case r.chunks <- buf[:n]:
+			case <-r.stopped:
+				return
+			}
+		}
+		if err != nil {
+			r.err = err
+			return
+		}
+	}
+}
+
+// Input that ends in the middle of a sequence waits for the rest of it, but
+// only briefly, since a lone escape is also what the esc key sends.
+func (r *readerInput) Next() ([]Event, error) {
+	r.once.Do(func() { go r.read() })
+	for {
+		var timeout <-chan time.Time
+		if len(r.pending) > 0 {
+			timeout = time.After(escapeTimeout)
+		}
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				return nil, r.err
+			}
+			events, rest := decodeInput(append(r.pending, chunk...))
+			r.pending = rest
+			if len(events) > 0 {
+				return events, nil
+			}
+		case <-timeout:
+			events := flushInput(r.pending)
+			r.pending = nil
+			if len(events) > 0 {
+				return events, nil
+			}
+		}
+	}
+}
+
+type terminalInput struct {
+	*readerInput
+	tty     *os.File
+	console console.Console
+}
+
+// The console keeps output processing on in raw mode, so the picker can
+// keep rendering lines with a plain newline.
+func openTerminalInput() (*terminalInput, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, err
+	}
+	c, err := console.ConsoleFromFile(tty)
+	if err == nil {
+		err = c.SetRaw()
+	}
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+	return &terminalInput{readerInput: newReaderInput(tty), tty: tty, console: c}, nil
+}
+
+func (t *terminalInput) Close() error {
+	t.stop()
+	t.console.Reset()
+	return t.tty.Close()
+}
+
+var csiKeys = map[string]keys.KeyCode{
+	"A": keys.Up, "B": keys.Down, "C": keys.Right, "D": keys.Left,
+	"H": keys.Home, "F": keys.End, "Z": keys.ShiftTab,
+	"1~": keys.Home, "7~": keys.Home, "4~": keys.End, "8~": keys.End,
+	"5~": keys.PgUp, "6~": keys.PgDown, "3~": keys.Delete,
+	"11~": keys.F1, "12~": keys.F2, "13~": keys.F3, "14~": keys.F4,
+	"15~": keys.F5, "17~": keys.F6, "18~": keys.F7, "19~": keys.F8,
+	"20~": keys.F9, "21~": keys.F10, "23~": keys.F11, "24~": keys.F12,
+}
+
+var ss3Keys = map[byte]keys.KeyCode{
+	'A': keys.Up, 'B': keys.Down, 'C': keys.Right, 'D': keys.Left,
+	'H': keys.Home, 'F': keys.End,
+	'P': keys.F1, 'Q': keys.F2, 'R': keys.F3, 'S': keys.F4,
+}
+
+var ctrlArrows = map[keys.KeyCode]keys.KeyCode{
+	keys.Up: keys.CtrlUp, keys.Down: keys.CtrlDown, keys.Right: keys.CtrlRight, keys.Left: keys.CtrlLeft,
+}
+
+// Several keys, a paste or a burst of mouse reports can arrive in a single
+// read, so the input is decoded into as many events as it holds. Incomplete
+// sequences at the end are handed back to be completed by the next read.
+func decodeInput(b []byte) ([]Event, []byte) {
+	events := []Event{}
+	for len(b) > 0 {
+		e, size := decodeEvent(b)
+		if size == 0 {
+			break
+		}
+		if e != nil {
+			events = append(events, *e)
+		}
+		b = b[size:]
+	}
+	return events, b
+}
+
+// Whatever is still pending once the rest of a sequence failed to arrive is
+// taken as typed, starting with the esc key.
+func flushInput(b []byte) []Event {
+	events := []Event{}
+	for len(b) > 0 {
+		decoded, rest := decodeInput(b)
+		events = append(events, decoded...)
+		if len(rest) < 1 {
+			break
+		}
+		if rest[0] == 0x1b {
+			events = append(events, Event{Key: keys.Key{Code: keys.Esc}})
+		}
+		b = rest[1:]
+	}
+	return events
+}
+
+func decodeEvent(b []byte) (*Event, int) {
+	if b[0] == 0x1b {
+		return decodeEscape(b)
+	}
+	if b[0] < 0x20 || b[0] == 0x7f {
+		if b[0] == 0x08 {
+			return &Event{Key: keys.Key{Code: keys.Backspace}}, 1
+		}
+		return &Event{Key: keys.Key{Code: keys.KeyCode(b[0])}}, 1
+	}
+	// Anything up to the next escape or control key is typed or pasted text,
+	// line feeds only come from pasted lines and are kept in the text.
+	end := len(b)
+	for i, c := range b {
+		if (c < 0x20 && c != '\n') || c == 0x7f {
+			end = i
+			break
+		}
+	}
+	runes := []rune{}
+	size := 0
+	for size < end {
+		if !utf8.FullRune(b[size:end]) {
+			if end == len(b) {
+				break
+			}
+			size++
+			continue
+		}
+		r, width := utf8.DecodeRune(b[size:end])
+		if r != utf8.RuneError {
+			runes = append(runes, r)
+		}
+		size += width
+	}
+	if size == 0 {
+		return nil, 0
+	}
+	if len(runes) < 1 {
+		return nil, size
+	}
+	if len(runes) == 1 && runes[0] == ' ' {
+		return &Event{Key: keys.Key{Code: keys.Space, Runes: runes}}, size
+	}
+	return &Event{Key: keys.Key{Code: keys.RuneKey, Runes: runes}}, size
+}
+
+func decodeEscape(b []byte) (*Event, int) {
+	if len(b) == 1 {
+		return nil, 0
+	}
+	switch b[1] {
+	case '[':
+		return decodeCSI(b)
+	case 'O':
+		if len(b) < 3 {
+			return nil, 0
+		}
+		if code, ok := ss3Keys[b[2]]; ok {
+			return &Event{Key: keys.Key{Code: code}}, 3
+		}
+		return nil, 3
+	case 0x1b:
+		e, size := decodeEvent(b[1:])
+		if size == 0 {
+			return nil, 0
+		}
+		if e != nil {
+			e.Key.AltPressed = true
+		}
+		return e, size + 1
+	case 0x7f, 0x08:
+		return &Event{Key: keys.Key{Code: keys.Backspace, AltPressed: true}}, 2
+	case '\r':
+		return &Event{Key: keys.Key{Code: keys.Enter, AltPressed: true}}, 2
+	}
+	if !utf8.FullRune(b[1:]) {
+		return nil, 0
+	}
+	r, width := utf8.DecodeRune(b[1:])
+	return &Event{Key: keys.Key{Code: keys.RuneKey, Runes: []rune{r}, AltPressed: true}}, 1 + width
+}
+
+func decodeCSI(b []byte) (*Event, int) {
+	end := -1
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, 0
+	}
+	size := end + 1
+	params, final := string(b[2:end]), string(b[end])
+	if strings.HasPrefix(params, "<") && (final == "M" || final == "m") {
+		values := parseParams(strings.TrimPrefix(params, "<"))
+		if len(values) != 3 {
+			return nil, size
+		}
+		return &Event{Mouse: &MouseEvent{Button: values[0], X: values[1], Y: values[2], Release: final == "m"}}, size
+	}
+	values := parseParams(params)
+	if final == "R" && len(values) == 2 {
+		return &Event{CursorRow: values[0]}, size
+	}
+	name := final
+	if final == "~" && len(values) > 0 {
+		name = strconv.Itoa(values[0]) + final
+	}
+	code, ok := csiKeys[name]
+	if !ok {
+		return nil, size
+	}
+	key := keys.Key{Code: code}
+	if modifier := modifierParam(values); modifier > 1 {
+		modifier--
+		key.AltPressed = modifier&2 != 0
+		if ctrl, ok := ctrlArrows[code]; ok && modifier&4 != 0 {
+			key.Code = ctrl
+		}
+	}
+	return &Event{Key: key}, size
+}
+
+// Modified keys carry 1 plus a bitmask of shift (1), alt (2) and ctrl (4)
+// as their second parameter, like "\033[1;5C" for ctrl+right.
+func modifierParam(values []int) int {
+	if len(values) > 1 {
+		return values[1]
+	}
+	return 0
+}
+
+func parseParams(params string) []int {
+	values := []int{}
+	if params == "" {
+		return values
+	}
+	for _, field := range strings.Split(params, ";") {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		values = append(values, value)
+	}
+	return values
+}
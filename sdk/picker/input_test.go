@@ -0,0 +1,186 @@
+package picker
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"atomicgo.dev/keyboard/keys"
+)
+
+func key(code keys.KeyCode) Event {
+	return Event{Key: keys.Key{Code: code}}
+}
+
+func altKey(code keys.KeyCode) Event {
+	return Event{Key: keys.Key{Code: code, AltPressed: true}}
+}
+
+func runes(text string) Event {
+	return Event{Key: keys.Key{Code: keys.RuneKey, Runes: []rune(text)}}
+}
+
+func altRune(r rune) Event {
+	return Event{Key: keys.Key{Code: keys.RuneKey, Runes: []rune{r}, AltPressed: true}}
+}
+
+func mouse(button, x, y int, release bool) Event {
+	return Event{Mouse: &MouseEvent{Button: button, X: x, Y: y, Release: release}}
+}
+
+func TestDecodeInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		events []Event
+		rest   string
+	}{
+		{"text", "web-1", []Event{runes("web-1")}, ""},
+		{"utf-8", "héllo 東京", []Event{runes("héllo 東京")}, ""},
+		{"space", " ", []Event{{Key: keys.Key{Code: keys.Space, Runes: []rune{' '}}}}, ""},
+		{"enter", "\r", []Event{key(keys.Enter)}, ""},
+		{"tab", "\t", []Event{key(keys.Tab)}, ""},
+		{"backspace", "\x7f", []Event{key(keys.Backspace)}, ""},
+		{"ctrl+h", "\x08", []Event{key(keys.Backspace)}, ""},
+		{"ctrl+c", "\x03", []Event{key(keys.CtrlC)}, ""},
+		{"keys and text", "ab\x17c\r", []Event{runes("ab"), key(keys.CtrlW), runes("c"), key(keys.Enter)}, ""},
+		{"pasted lines", "web\napi\r", []Event{runes("web\napi"), key(keys.Enter)}, ""},
+		{"arrows", "\x1b[A\x1b[B\x1b[C\x1b[D", []Event{key(keys.Up), key(keys.Down), key(keys.Right), key(keys.Left)}, ""},
+		{"ss3 arrows", "\x1bOA\x1bOD", []Event{key(keys.Up), key(keys.Left)}, ""},
+		{"home and end", "\x1b[H\x1b[F\x1b[1~\x1b[4~\x1b[7~\x1b[8~\x1bOH", []Event{key(keys.Home), key(keys.End), key(keys.Home), key(keys.End), key(keys.Home), key(keys.End), key(keys.Home)}, ""},
+		{"paging", "\x1b[5~\x1b[6~", []Event{key(keys.PgUp), key(keys.PgDown)}, ""},
+		{"delete and shift+tab", "\x1b[3~\x1b[Z", []Event{key(keys.Delete), key(keys.ShiftTab)}, ""},
+		{"function keys", "\x1bOP\x1b[12~\x1b[24~", []Event{key(keys.F1), key(keys.F2), key(keys.F12)}, ""},
+		{"ctrl arrows", "\x1b[1;5C\x1b[1;5D\x1b[1;5A\x1b[1;5B", []Event{key(keys.CtrlRight), key(keys.CtrlLeft), key(keys.CtrlUp), key(keys.CtrlDown)}, ""},
+		{"alt arrows", "\x1b[1;3C\x1b[1;3D", []Event{altKey(keys.Right), altKey(keys.Left)}, ""},
+		{"alt home", "\x1b[1;3H\x1b[1;3F", []Event{altKey(keys.Home), altKey(keys.End)}, ""},
+		{"esc prefixed arrow", "\x1b\x1b[D", []Event{altKey(keys.Left)}, ""},
+		{"alt+backspace", "\x1b\x7f\x1b\x08", []Event{altKey(keys.Backspace), altKey(keys.Backspace)}, ""},
+		{"alt+enter", "\x1b\r", []Event{altKey(keys.Enter)}, ""},
+		{"alt+rune", "\x1bb\x1b<\x1bé", []Event{altRune('b'), altRune('<'), altRune('é')}, ""},
+		{"sgr press and release", "\x1b[<0;12;5M\x1b[<0;12;5m", []Event{mouse(0, 12, 5, false), mouse(0, 12, 5, true)}, ""},
+		{"sgr wheel", "\x1b[<64;1;2M\x1b[<65;1;2M", []Event{mouse(64, 1, 2, false), mouse(65, 1, 2, false)}, ""},
+		{"cursor position", "\x1b[24;1R", []Event{{CursorRow: 24}}, ""},
+		{"unknown csi", "\x1b[99~a", []Event{runes("a")}, ""},
+		{"lone esc", "\x1b", []Event{}, "\x1b"},
+		{"trailing esc", "a\x1b", []Event{runes("a")}, "\x1b"},
+		{"split csi", "\x1b[", []Event{}, "\x1b["},
+		{"split csi params", "a\x1b[1;5", []Event{runes("a")}, "\x1b[1;5"},
+		{"split ss3", "\x1bO", []Event{}, "\x1bO"},
+		{"split sgr", "\x1b[<0;12", []Event{}, "\x1b[<0;12"},
+		{"split utf-8", "a\xe6\x9d", []Event{runes("a")}, "\xe6\x9d"},
+		{"split alt utf-8", "\x1b\xc3", []Event{}, "\x1b\xc3"},
+	}
+	for _, test := range tests {
+		events, rest := decodeInput([]byte(test.input))
+		if !reflect.DeepEqual(events, test.events) {
+			t.Errorf("%s: events are %+v, want %+v", test.name, events, test.events)
+		}
+		if string(rest) != test.rest {
+			t.Errorf("%s: rest is %q, want %q", test.name, rest, test.rest)
+		}
+	}
+}
+
+// Sequences split over several reads decode the same as when read at once.
+func TestDecodeInputAcrossReads(t *testing.T) {
+	input := "ab\x1b[1;5C東\x1b[<0;3;4M\x1b[<0;3;4m\x1b\x7f\x1b[24;1R\r"
+	whole, rest := decodeInput([]byte(input))
+	if len(rest) > 0 {
+		t.Fatalf("rest is %q", rest)
+	}
+	for size := 1; size < len(input); size++ {
+		events, pending := []Event{}, []byte{}
+		for i := 0; i < len(input); i += size {
+			decoded, rest := decodeInput(append(pending, input[i:min(i+size, len(input))]...))
+			events = append(events, decoded...)
+			pending = rest
+		}
+		if len(pending) > 0 {
+			t.Errorf("reads of %d bytes: %q is left pending", size, pending)
+		}
+		// Text is split into several rune keys when it spans reads.
+		if text, keys := joinText(events), joinText(whole); text != keys {
+			t.Errorf("reads of %d bytes: text is %q, want %q", size, text, keys)
+		}
+		if other, want := withoutText(events), withoutText(whole); !reflect.DeepEqual(other, want) {
+			t.Errorf("reads of %d bytes: events are %+v, want %+v", size, other, want)
+		}
+	}
+}
+
+func joinText(events []Event) string {
+	text := ""
+	for _, e := range events {
+		if e.Key.Code == keys.RuneKey && !e.Key.AltPressed {
+			text += string(e.Key.Runes)
+		}
+	}
+	return text
+}
+
+func withoutText(events []Event) []Event {
+	other := []Event{}
+	for _, e := range events {
+		if e.Key.Code != keys.RuneKey || e.Key.AltPressed {
+			other = append(other, e)
+		}
+	}
+	return other
+}
+
+func TestFlushInput(t *testing.T) {
+	tests := []struct {
+		input  string
+		events []Event
+	}{
+		{"\x1b", []Event{key(keys.Esc)}},
+		{"\x1b\x1b", []Event{key(keys.Esc), key(keys.Esc)}},
+		{"\x1b[", []Event{key(keys.Esc), runes("[")}},
+		{"\x1bO", []Event{key(keys.Esc), runes("O")}},
+		{"\xe6\x9d", []Event{}},
+	}
+	for _, test := range tests {
+		if events := flushInput([]byte(test.input)); !reflect.DeepEqual(events, test.events) {
+			t.Errorf("%q: events are %+v, want %+v", test.input, events, test.events)
+		}
+	}
+}
+
+func TestEventSourceHoldsEscape(t *testing.T) {
+	reader, writer := io.Pipe()
+	source := NewEventSource(reader)
+	next := func() []Event {
+		t.Helper()
+		result := make(chan []Event, 1)
+		go func() {
+			events, _ := source.Next()
+			result <- events
+		}()
+		select {
+		case events := <-result:
+			return events
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+		return nil
+	}
+	// An arrow whose escape arrives on its own is still an arrow.
+	go func() {
+		writer.Write([]byte("\x1b"))
+		time.Sleep(escapeTimeout / 5)
+		writer.Write([]byte("[A"))
+	}()
+	if events := next(); !reflect.DeepEqual(events, []Event{key(keys.Up)}) {
+		t.Errorf("split arrow decoded to %+v", events)
+	}
+	go writer.Write([]byte("\x1b"))
+	if events := next(); !reflect.DeepEqual(events, []Event{key(keys.Esc)}) {
+		t.Errorf("lone escape decoded to %+v", events)
+	}
+	writer.Close()
+	if events, err := source.Next(); events != nil || err != io.EOF {
+		t.Errorf("closed source returned %+v, %v", events, err)
+	}
+}
@@ -0,0 +1,107 @@
+package picker
+
+import (
+	"strconv"
+
+	"atomicgo.dev/keyboard/keys"
+)
+
+// Mouse reporting lets the wheel move the highlight and a click highlight a
+// row, clicking the highlighted row picks it. It is optional since terminals
+// only select text with shift held while it is on.
+func (p *Picker[T]) WithMouse(enabled bool) {
+	p.mouse = enabled
+}
+
+// The window always spans maxHeight rows and is only moved as far as needed
+// to keep the highlighted row inside of it.
+func (p *Picker[T]) moveSelection(index int) {
+	if len(p.filtered) < 1 {
+		return
+	}
+	index = max(0, min(index, len(p.filtered)-1))
+	if index == p.selectedIndex {
+		return
+	}
+	p.selectedIndex = index
+	if index < p.windowStart {
+		p.windowStart = index
+		p.windowEnd = index + p.maxHeight
+	}
+	if index >= p.windowEnd {
+		p.windowEnd = index + 1
+		p.windowStart = p.windowEnd - p.maxHeight
+	}
+	p.render()
+}
+
+func (p *Picker[T]) scrollPage(direction int) {
+	if len(p.filtered) < 1 {
+		return
+	}
+	start := max(0, min(p.windowStart+direction*p.maxHeight, len(p.filtered)-p.maxHeight))
+	p.windowStart, p.windowEnd = start, start+p.maxHeight
+	index := max(0, min(p.selectedIndex+direction*p.maxHeight, len(p.filtered)-1))
+	if index < p.windowStart || index >= p.windowEnd {
+		index = max(p.windowStart, min(index, p.windowEnd-1))
+	}
+	p.selectedIndex = -1
+	p.moveSelection(index)
+}
+
+func (p *Picker[T]) onJumpKey(key keys.Key) bool {
+	switch {
+	case key.Code == keys.RuneKey && !key.AltPressed:
+		for _, r := range key.Runes {
+			if r >= '0' && r <= '9' {
+				p.jumpTerm += string(r)
+			}
+		}
+	case key.Code == keys.Backspace && len(p.jumpTerm) > 0:
+		p.jumpTerm = p.jumpTerm[:len(p.jumpTerm)-1]
+	case key.Code == keys.Enter:
+		p.jumping = false
+		if row, err := strconv.Atoi(p.jumpTerm); err == nil {
+			p.moveSelection(row - 1)
+		}
+	case key.Code == keys.Esc, key.Code == keys.CtrlG:
+		p.jumping = false
+	}
+	p.render()
+	return false
+}
+
+func (p *Picker[T]) onMouse(mouse MouseEvent) bool {
+	if mouse.Release || mouse.Button&mouseMotion != 0 {
+		return false
+	}
+	switch mouse.Button &^ 0x1c {
+	case mouseWheelUp:
+		p.moveSelection(p.selectedIndex - 1)
+	case mouseWheelDown:
+		p.moveSelection(p.selectedIndex + 1)
+	case mouseLeft:
+		if p.originRow < 1 {
+			return false
+		}
+		index := p.windowStart + mouse.Y - p.originRow - p.rowsTop
+		if index < p.windowStart || index >= min(p.windowEnd, len(p.filtered)) {
+			return false
+		}
+		if index == p.selectedIndex {
+			return true
+		}
+		p.moveSelection(index)
+	}
+	return false
+}
+
+// Alt with home or end, or alt+< and alt+> like in emacs, always jump to the
+// first or last row, while plain home and end only do once the filter cursor
+// is already at that end.
+func isAltKey(key keys.Key, code keys.KeyCode, r rune) bool {
+	if !key.AltPressed {
+		return false
+	}
+	return key.Code == code || (key.Code == keys.RuneKey && len(key.Runes) == 1 && key.Runes[0] == r)
+}
@@ -1,9 +1,11 @@
 package picker
 
 import (
+	"fmt"
+	"io"
 	"strings"
+	"sync"
 
-	"atomicgo.dev/keyboard"
 	"atomicgo.dev/keyboard/keys"
 
 	"github.com/null93/aws-knox/pkg/ansi"
@@ -12,13 +14,15 @@ import (
 	. "github.com/null93/aws-knox/sdk/style"
 )
 
-type picker struct {
+type Picker[T comparable] struct {
+	mu             sync.Mutex
 	actions        []action
-	options        []option
-	filtered       []*option
+	options        []Option[T]
+	filtered       []*Option[T]
 	initialIndex   int
 	selectedIndex  int
-	term           string
+	term           []rune
+	cursor         int
 	filterStrategy string
 	title          string
 	longestCols    []int
@@ -28,29 +32,49 @@ type picker struct {
 	windowEnd      int
 	headers        []string
 	loading        bool
+	status         string
+	multiSelect    bool
+	sortColumn     int
+	sortDescending bool
+	onSortChange   func(string)
+	previewEnabled bool
+	previewVisible bool
+	mouse          bool
+	listening      bool
+	originRow      int
+	rowsTop        int
+	jumping        bool
+	jumpTerm       string
+	input          EventSource
+	out            io.Writer
 }
 
-type option struct {
-	Columns []string
-	Value   interface{}
-	Debug   string
+type Option[T comparable] struct {
+	Columns  []string
+	Value    T
+	Dimmed   bool
+	Selected bool
+	SortKeys []string
+	Preview  func() []string
+	Debug    string
 }
 
 type action struct {
 	key         keys.KeyCode
 	name        string
 	description string
+	callback    func()
 }
 
-func NewPicker() *picker {
-	p := picker{
+func NewPicker[T comparable]() *Picker[T] {
+	p := Picker[T]{
 		actions:        []action{},
-		options:        []option{},
-		filtered:       []*option{},
+		options:        []Option[T]{},
+		filtered:       []*Option[T]{},
 		initialIndex:   0,
 		selectedIndex:  0,
 		title:          "Please Pick One",
-		term:           "",
+		term:           []rune{},
 		filterStrategy: "fuzzy",
 		longestCols:    []int{},
 		emptyMessage:   "Nothing Found",
@@ -59,80 +83,138 @@ func NewPicker() *picker {
 		windowEnd:      5,
 		headers:        []string{},
 		loading:        false,
+		sortColumn:     -1,
 	}
 	return &p
 }
 
-func (p *picker) WithFilterStrategy(strategy string) {
+func (p *Picker[T]) WithFilterStrategy(strategy string) {
 	p.filterStrategy = strategy
 }
 
-func (p *picker) WithMaxHeight(maxHeight int) {
+func (p *Picker[T]) WithMaxHeight(maxHeight int) {
 	p.maxHeight = maxHeight
 	p.windowStart = 0
 	p.windowEnd = maxHeight
 }
 
-func (p *picker) WithEmptyMessage(emptyMessage string) {
+func (p *Picker[T]) WithEmptyMessage(emptyMessage string) {
 	p.emptyMessage = emptyMessage
 }
 
-func (p *picker) WithTitle(title string) {
+func (p *Picker[T]) WithTitle(title string) {
 	p.title = title
 }
 
-func (p *picker) WithInitialIndex(index int) {
+// In multi-select mode space and tab toggle the highlighted row, unless an
+// action is bound to them, and ctrl+a toggles every filtered row.
+func (p *Picker[T]) WithMultiSelect() {
+	p.multiSelect = true
+}
+
+// Input and output default to the terminal, they are replaced to drive the
+// picker from a script, like the virtual terminal in pkg/vterm.
+func (p *Picker[T]) WithInput(input EventSource) {
+	p.input = input
+}
+
+func (p *Picker[T]) WithOutput(out io.Writer) {
+	p.out = out
+}
+
+func (p *Picker[T]) output() io.Writer {
+	if p.out != nil {
+		return p.out
+	}
+	return color.Writer
+}
+
+func (p *Picker[T]) WithInitialIndex(index int) {
 	p.initialIndex = index
 }
 
-func (p *picker) WithHeaders(headers ...string) {
+func (p *Picker[T]) WithHeaders(headers ...string) {
 	p.headers = headers
-	for i, header := range headers {
-		if len(p.longestCols) <= i {
-			p.longestCols = append(p.longestCols, 0)
-		}
-		if len(header) > p.longestCols[i] {
-			p.longestCols[i] = len(header)
-		}
-	}
+	p.growCols(headers)
+}
+
+func (p *Picker[T]) AddOption(value T, cols ...string) {
+	p.addOption(value, false, cols...)
+}
+
+func (p *Picker[T]) AddDimmedOption(value T, cols ...string) {
+	p.addOption(value, true, cols...)
 }
 
-func (p *picker) AddOption(value interface{}, cols ...string) {
-	o := option{
+func (p *Picker[T]) addOption(value T, dimmed bool, cols ...string) {
+	o := Option[T]{
 		Value:   value,
 		Columns: cols,
+		Dimmed:  dimmed,
 	}
-	for i, label := range cols {
-		if len(p.longestCols) <= i {
-			p.longestCols = append(p.longestCols, 0)
+	p.growCols(cols)
+	p.options = append(p.options, o)
+	p.filtered = append(p.filtered, &p.options[len(p.options)-1])
+}
+
+func (p *Picker[T]) UpdateOption(value T, cols ...string) {
+	for i := range p.options {
+		if p.options[i].Value != value {
+			continue
 		}
-		if len(label) > p.longestCols[i] {
-			p.longestCols[i] = len(label)
+		p.options[i].Columns = cols
+		p.growCols(cols)
+	}
+}
+
+func (p *Picker[T]) SetOptionDimmed(value T, dimmed bool) {
+	for i := range p.options {
+		if p.options[i].Value == value {
+			p.options[i].Dimmed = dimmed
 		}
 	}
-	p.options = append(p.options, o)
-	p.filtered = append(p.filtered, &o)
 }
 
-func (p *picker) AddAction(key keys.KeyCode, name string, description string) {
-	p.actions = append(p.actions, action{key, name, description})
+func (p *Picker[T]) RemoveOption(value T) {
+	options := []Option[T]{}
+	for _, o := range p.options {
+		if o.Value != value {
+			options = append(options, o)
+		}
+	}
+	p.options = options
+}
+
+func (p *Picker[T]) AddAction(key keys.KeyCode, name string, description string) {
+	p.actions = append(p.actions, action{key, name, description, nil})
 }
 
-func (p *picker) SetLoading(loading bool) {
+func (p *Picker[T]) AddCallbackAction(key keys.KeyCode, name string, description string, callback func()) {
+	p.actions = append(p.actions, action{key, name, description, callback})
+}
+
+func (p *Picker[T]) SetLoading(loading bool) {
 	p.loading = loading
 }
 
-func (p *picker) filter() {
-	p.filtered = []*option{}
+// The status is shown in the help menu next to the item count, like a warning
+// that some items are incomplete.
+func (p *Picker[T]) SetStatus(status string) {
+	p.status = status
+}
+
+func (p *Picker[T]) filter() {
+	term := string(p.term)
+	p.filtered = []*Option[T]{}
 	p.selectedIndex = 0
 	p.windowStart = 0
 	p.windowEnd = p.maxHeight
 
 	if p.filterStrategy == "fuzzy" {
-		optionsMap := map[string]*option{}
+		optionsMap := map[string]*Option[T]{}
 		fullValues := []string{}
 		for i, option := range p.options {
-			if p.term == "" {
+			if term == "" {
 				p.filtered = append(p.filtered, &p.options[i])
 				continue
 			}
@@ -140,9 +222,9 @@ func (p *picker) filter() {
 			fullValues = append(fullValues, fullValue)
 			optionsMap[fullValue] = &p.options[i]
 		}
-		if p.term != "" {
+		if term != "" {
 			ngramSize := 3
-			results := cosine.Search(fullValues, strings.ToLower(p.term), ngramSize)
+			results := cosine.Search(fullValues, strings.ToLower(term), ngramSize)
 			averageSimilarity := 0.0
 			minimumSimilarity := 0.15
 			for _, result := range results {
@@ -161,12 +243,12 @@ func (p *picker) filter() {
 		}
 	} else {
 		for i, option := range p.options {
-			if p.term == "" {
+			if term == "" {
 				p.filtered = append(p.filtered, &p.options[i])
 				continue
 			}
 			for _, col := range option.Columns {
-				if strings.Contains(strings.ToLower(col), strings.ToLower(p.term)) {
+				if strings.Contains(strings.ToLower(col), strings.ToLower(term)) {
 					p.filtered = append(p.filtered, &p.options[i])
 					break
 				}
@@ -174,160 +256,403 @@ func (p *picker) filter() {
 		}
 	}
 
+	p.sort()
 	if len(p.filtered) < 1 {
 		p.selectedIndex = -1
 	}
 }
 
-func (p *picker) render() {
-	ansi.ClearDown()
+// Each frame is built up front and written at once, so renders from the
+// key handler and from background updates don't interleave.
+func (p *Picker[T]) render() {
+	frame := &strings.Builder{}
+	out := ansi.To(frame)
+	out.ClearDown()
 	lightGray := color.ToForeground(LightGrayColor).Decorator()
 	darkGray := color.ToForeground(DarkGrayColor).Decorator()
-	DefaultStyle.Printfln("")
-	TitleStyle.Printf(" %s", p.title)
-	DefaultStyle.Printfln("")
-	DefaultStyle.Printf(lightGray(" filter: %s", SearchTermStyle.Sprintf(p.term)))
-	CursorStyle.Printfln("█")
+	DefaultStyle.Fprintfln(frame, "")
+	TitleStyle.Fprintf(frame, " %s", p.title)
+	DefaultStyle.Fprintfln(frame, "")
+	switch {
+	case p.jumping:
+		DefaultStyle.Fprintf(frame, "%s", lightGray(" go to row (1-%d): %s", len(p.filtered), SearchTermStyle.Sprintf("%s", p.jumpTerm)))
+		CursorStyle.Fprintfln(frame, "█")
+	case p.cursor < len(p.term):
+		DefaultStyle.Fprintf(frame, "%s", lightGray(" filter: %s", SearchTermStyle.Sprintf("%s", string(p.term[:p.cursor]))))
+		TermCursorStyle.Fprintf(frame, "%s", string(p.term[p.cursor]))
+		DefaultStyle.Fprintfln(frame, "%s", SearchTermStyle.Sprintf("%s", string(p.term[p.cursor+1:])))
+	default:
+		DefaultStyle.Fprintf(frame, "%s", lightGray(" filter: %s", SearchTermStyle.Sprintf("%s", string(p.term))))
+		CursorStyle.Fprintfln(frame, "█")
+	}
 	if p.windowStart > 0 {
-		DefaultStyle.Printfln(" " + darkGray("…"))
+		DefaultStyle.Fprintfln(frame, " "+darkGray("…"))
 	} else {
-		DefaultStyle.Printfln("")
+		DefaultStyle.Fprintfln(frame, "")
 	}
+	rows := []string{}
+	p.rowsTop = 4
 	if len(p.headers) > 0 && len(p.filtered) > 0 {
+		p.rowsTop++
+		row := ""
+		if p.multiSelect {
+			row += HeaderStyle.Sprintf("   ")
+		}
 		for i, header := range p.headers {
-			HeaderStyle.Printf(" %-*s ", p.longestCols[i], header)
+			row += HeaderStyle.Sprintf(" %s ", pad(header+p.sortIndicator(i), p.colWidth(i)))
 		}
-		HeaderStyle.Printfln("")
+		rows = append(rows, row)
 	}
 	if len(p.filtered) < 1 {
-		SubTitleStyle.Printfln(" %s ", p.emptyMessage)
+		rows = append(rows, SubTitleStyle.Sprintf(" %s ", p.emptyMessage))
 	}
 	for index, option := range p.filtered {
 		if index < p.windowStart || index >= p.windowEnd {
 			continue
 		}
 		rowStyle := OptionStyle
+		if option.Dimmed {
+			rowStyle = DimmedOptionStyle
+		}
 		if index == p.selectedIndex {
 			rowStyle = HighlightOptionStyle
 		}
+		row := ""
+		if p.multiSelect {
+			marker := "○"
+			if option.Selected {
+				marker = "◉"
+			}
+			row += rowStyle.Sprintf(" %s ", marker)
+		}
 		for i, col := range option.Columns {
-			rowStyle.Printf(" %-*s ", p.longestCols[i], col)
+			row += rowStyle.Sprintf(" %s ", pad(col, p.colWidth(i)))
 		}
 		if option.Debug != "" {
-			rowStyle.Printf("%s", darkGray(" DEBUG: "+option.Debug+" "))
+			row += rowStyle.Sprintf("%s", darkGray(" DEBUG: "+option.Debug+" "))
 		}
-		rowStyle.Printfln("")
+		rows = append(rows, row)
+	}
+	preview, beside := p.preview()
+	if beside {
+		rows = p.besidePreview(rows, preview)
+	}
+	for _, row := range rows {
+		fmt.Fprintln(frame, row)
 	}
 	if p.windowEnd < len(p.filtered) {
-		DefaultStyle.Printfln(" " + darkGray("…"))
+		DefaultStyle.Fprintfln(frame, " "+darkGray("…"))
 	} else {
-		DefaultStyle.Printfln("")
+		DefaultStyle.Fprintfln(frame, "")
+	}
+	if !beside {
+		for _, line := range preview {
+			fmt.Fprintln(frame, line)
+		}
 	}
 	itemsLabel := "items"
 	if p.loading {
 		itemsLabel = "items (loading)"
 	}
 	helpMenu := darkGray(" %d/%d %s •", len(p.filtered), len(p.options), itemsLabel)
+	if p.status != "" {
+		helpMenu += color.ToForeground(YellowColor).Decorator()(" %s", p.status) + darkGray(" •")
+	}
+	if p.multiSelect {
+		helpMenu += darkGray(" %d selected •", p.selectedCount())
+	}
 	helpMenu += lightGray(" ↑ ") + darkGray("up •")
 	helpMenu += lightGray(" ↓ ") + darkGray("down •")
+	helpMenu += lightGray(" home/end ") + darkGray("first/last •")
 	helpMenu += lightGray(" enter ") + darkGray("choose •")
+	if p.multiSelect {
+		helpMenu += lightGray(" space ") + darkGray("select •")
+		helpMenu += lightGray(" ctl+a ") + darkGray("select all •")
+	}
+	if len(p.headers) > 0 && !p.hasAction(keys.CtrlS) {
+		helpMenu += lightGray(" ctl+s ") + darkGray("sort •")
+	}
+	if !p.hasAction(keys.CtrlG) {
+		helpMenu += lightGray(" ctl+g ") + darkGray("go to row •")
+	}
+	if p.previewEnabled && !p.hasAction(keys.CtrlO) {
+		helpMenu += lightGray(" ctl+o ") + darkGray("preview •")
+	}
 	for _, action := range p.actions {
 		helpMenu += lightGray(" %s ", action.name) + darkGray("%s •", action.description)
 	}
 	helpMenu += lightGray(" ctl+c ") + darkGray("quit") + color.ResetStyle
-	DefaultStyle.Printf(helpMenu)
-	DefaultStyle.Printfln("")
-	lines := len(p.filtered)
-	if p.maxHeight < lines {
-		lines = p.maxHeight
+	DefaultStyle.Fprintf(frame, helpMenu)
+	DefaultStyle.Fprintfln(frame, "")
+	lines := len(rows)
+	if !beside {
+		lines += len(preview)
 	}
-	if len(p.headers) > 0 {
-		lines++
+	out.MoveCursorUp(5 + lines + p.wrappedLines(helpMenu))
+	// Mouse reports use screen rows, so where the picker starts is asked for
+	// after every render in case drawing it scrolled the screen.
+	if p.mouse && p.listening {
+		out.QueryCursorPosition()
 	}
-	ansi.MoveCursorUp(6 + lines)
+	io.WriteString(p.output(), frame.String())
 }
 
-func (p *picker) Pick(initialFilter string) (*option, *keys.KeyCode) {
-	p.term = initialFilter
+func (p *Picker[T]) selectedCount() int {
+	count := 0
+	for _, o := range p.options {
+		if o.Selected {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *Picker[T]) hasAction(code keys.KeyCode) bool {
+	for _, action := range p.actions {
+		if action.key == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Picker[T]) toggleAll() {
+	allSelected := true
+	for _, o := range p.filtered {
+		allSelected = allSelected && o.Selected
+	}
+	for _, o := range p.filtered {
+		o.Selected = !allSelected
+	}
+}
+
+func (p *Picker[T]) listen(initialFilter string) *keys.KeyCode {
+	input := p.input
+	if input == nil {
+		var err error
+		if input, err = DefaultInput(); err != nil {
+			return nil
+		}
+		if closer, ok := input.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+	out := ansi.To(p.output())
+	out.HideCursor()
+	defer out.ClearDown()
+	defer out.ShowCursor()
+	if p.mouse {
+		out.EnableMouse()
+		defer out.DisableMouse()
+	}
+	p.mu.Lock()
+	p.setTerm(initialFilter)
 	p.filter()
 	if len(p.filtered) > p.initialIndex {
 		p.selectedIndex = p.initialIndex
 	} else {
 		p.selectedIndex = 0
 	}
-	ansi.HideCursor()
-	defer ansi.ClearDown()
-	defer ansi.ShowCursor()
+	p.listening = true
 	p.render()
-	var firedActionKeyCode *keys.KeyCode
-	keyboard.Listen(func(key keys.Key) (stop bool, err error) {
-		if key.Code == keys.CtrlC {
-			p.selectedIndex = -1
-			return true, nil
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.listening = false
+		p.mu.Unlock()
+	}()
+	for {
+		events, err := input.Next()
+		if err != nil {
+			return nil
 		}
-		if key.Code == keys.Up {
-			if p.selectedIndex > 0 {
-				p.selectedIndex--
-				if p.selectedIndex < p.windowStart {
-					p.windowStart--
-					p.windowEnd--
-				}
-				p.render()
+		for _, e := range events {
+			var stop bool
+			var firedActionKeyCode *keys.KeyCode
+			p.mu.Lock()
+			switch {
+			case e.Mouse != nil:
+				stop = p.onMouse(*e.Mouse)
+			case e.CursorRow > 0:
+				p.originRow = e.CursorRow
+			default:
+				stop, firedActionKeyCode = p.onKey(e.Key)
 			}
-		}
-		if key.Code == keys.Down {
-			if p.selectedIndex < len(p.filtered)-1 {
-				p.selectedIndex++
-				if p.selectedIndex >= p.windowEnd {
-					p.windowStart++
-					p.windowEnd++
-				}
-				p.render()
+			callback := p.callbackFor(firedActionKeyCode)
+			p.mu.Unlock()
+			// Callbacks run without the lock, so they can change the
+			// picker through Mutate.
+			if callback != nil {
+				callback()
+				continue
 			}
-		}
-		if key.Code == keys.Enter {
-			if p.selectedIndex > -1 {
-				return true, nil
+			if stop {
+				return firedActionKeyCode
 			}
 		}
-		if key.Code == keys.RuneKey || key.Code == keys.Space {
-			if p.term == "" && key.Code == keys.Space {
-				return false, nil
-			}
-			p.term += string(key.Runes)
-			p.filter()
-			p.render()
+	}
+}
+
+func (p *Picker[T]) callbackFor(code *keys.KeyCode) func() {
+	for _, action := range p.actions {
+		if code != nil && action.key == *code {
+			return action.callback
 		}
-		if key.Code == keys.Backspace {
-			if len(p.term) > 0 {
-				p.term = p.term[:len(p.term)-1]
-				p.filter()
-				p.render()
-			}
+	}
+	return nil
+}
+
+func (p *Picker[T]) onKey(key keys.Key) (bool, *keys.KeyCode) {
+	if key.Code == keys.CtrlC {
+		p.selectedIndex = -1
+		return true, nil
+	}
+	if p.jumping {
+		return p.onJumpKey(key), nil
+	}
+	switch {
+	case key.Code == keys.Up, key.Code == keys.CtrlP:
+		p.moveSelection(p.selectedIndex - 1)
+	case key.Code == keys.Down, key.Code == keys.CtrlN:
+		p.moveSelection(p.selectedIndex + 1)
+	case key.Code == keys.PgUp:
+		p.scrollPage(-1)
+	case key.Code == keys.PgDown:
+		p.scrollPage(1)
+	case isAltKey(key, keys.Home, '<'), key.Code == keys.Home && !key.AltPressed && p.cursor == 0:
+		p.moveSelection(0)
+		return false, nil
+	case isAltKey(key, keys.End, '>'), key.Code == keys.End && !key.AltPressed && p.cursor == len(p.term):
+		p.moveSelection(len(p.filtered) - 1)
+		return false, nil
+	case key.Code == keys.CtrlG && !p.hasAction(key.Code):
+		p.jumping, p.jumpTerm = true, ""
+		p.render()
+	}
+	if key.Code == keys.Enter {
+		if p.selectedIndex > -1 {
+			return true, nil
 		}
-		for _, action := range p.actions {
-			if key.Code == action.key {
-				firedActionKeyCode = &action.key
-				return true, nil
-			}
+	}
+	if p.multiSelect && (key.Code == keys.Space || key.Code == keys.Tab) && !p.hasAction(key.Code) {
+		if p.selectedIndex >= 0 && p.selectedIndex < len(p.filtered) {
+			p.filtered[p.selectedIndex].Selected = !p.filtered[p.selectedIndex].Selected
+			p.render()
 		}
 		return false, nil
-	})
-	if p.selectedIndex < 0 {
-		return nil, firedActionKeyCode
 	}
-	if p.selectedIndex >= len(p.filtered) {
-		return nil, firedActionKeyCode
+	if p.multiSelect && key.Code == keys.CtrlA && !p.hasAction(key.Code) {
+		p.toggleAll()
+		p.render()
+		return false, nil
+	}
+	if key.Code == keys.CtrlS && len(p.headers) > 0 && !p.hasAction(key.Code) {
+		p.cycleSort()
+		p.update()
+		return false, nil
+	}
+	if key.Code == keys.CtrlO && p.previewEnabled && !p.hasAction(key.Code) {
+		p.previewVisible = !p.previewVisible
+		p.render()
+		return false, nil
 	}
-	return p.filtered[p.selectedIndex], firedActionKeyCode
+	edited, moved := false, false
+	switch {
+	case key.Code == keys.Space && len(p.term) < 1:
+		return false, nil
+	case (key.Code == keys.RuneKey && !key.AltPressed) || key.Code == keys.Space:
+		edited = p.insert(key.Runes)
+	case key.Code == keys.Backspace && key.AltPressed, key.Code == keys.CtrlW:
+		edited = p.deleteBack(p.wordStart())
+	case key.Code == keys.Backspace:
+		edited = p.deleteBack(p.cursor - 1)
+	case key.Code == keys.CtrlU:
+		edited = p.deleteBack(0)
+	case key.Code == keys.Left && key.AltPressed, key.Code == keys.CtrlLeft:
+		moved = p.moveCursor(p.wordStart())
+	case key.Code == keys.Right && key.AltPressed, key.Code == keys.CtrlRight:
+		moved = p.moveCursor(p.wordEnd())
+	case key.Code == keys.Left:
+		moved = p.moveCursor(p.cursor - 1)
+	case key.Code == keys.Right:
+		moved = p.moveCursor(p.cursor + 1)
+	case key.Code == keys.Home:
+		moved = p.moveCursor(0)
+	case key.Code == keys.End:
+		moved = p.moveCursor(len(p.term))
+	}
+	if edited {
+		p.filter()
+	}
+	if edited || moved {
+		p.render()
+	}
+	for _, action := range p.actions {
+		if key.Code == action.key {
+			return action.callback == nil, &action.key
+		}
+	}
+	return false, nil
+}
+
+func (p *Picker[T]) highlighted() *Option[T] {
+	if p.selectedIndex < 0 || p.selectedIndex >= len(p.filtered) {
+		return nil
+	}
+	return p.filtered[p.selectedIndex]
+}
+
+func (p *Picker[T]) Pick(initialFilter string) (*Option[T], *keys.KeyCode) {
+	firedActionKeyCode := p.listen(initialFilter)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.highlighted(), firedActionKeyCode
 }
 
-func (p *picker) Update() {
-	var selectedValue interface{}
+// PickMany returns the selected options in the order they were added, or the
+// highlighted one if nothing was selected. It is empty if the user quit.
+func (p *Picker[T]) PickMany(initialFilter string) ([]*Option[T], *keys.KeyCode) {
+	firedActionKeyCode := p.listen(initialFilter)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	highlighted := p.highlighted()
+	if highlighted == nil && firedActionKeyCode == nil {
+		return []*Option[T]{}, nil
+	}
+	selected := []*Option[T]{}
+	for i := range p.options {
+		if p.options[i].Selected {
+			selected = append(selected, &p.options[i])
+		}
+	}
+	if len(selected) < 1 && highlighted != nil {
+		selected = append(selected, highlighted)
+	}
+	return selected, firedActionKeyCode
+}
+
+func (p *Picker[T]) Update() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.update()
+}
+
+// Options are changed through Mutate while the picker is shown, like from a
+// goroutine that streams them in. The change runs between key presses and is
+// followed by an update, so the rows never point at removed options.
+func (p *Picker[T]) Mutate(change func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	change()
+	p.update()
+}
+
+func (p *Picker[T]) update() {
+	var selectedValue *T
 	if p.selectedIndex >= 0 && p.selectedIndex < len(p.filtered) {
-		selectedValue = p.filtered[p.selectedIndex].Value
+		selectedValue = &p.filtered[p.selectedIndex].Value
 	}
-	previousTerm := p.term
+	previousTerm, previousCursor := p.term, p.cursor
 	previousWindowStart := p.windowStart
 	previousWindowEnd := p.windowEnd
 
@@ -335,14 +660,34 @@ func (p *picker) Update() {
 
 	// Try to restore selection by value
 	p.selectedIndex = 0
+	if len(p.filtered) < 1 {
+		p.selectedIndex = -1
+	}
 	for i, o := range p.filtered {
-		if selectedValue != nil && o.Value == selectedValue {
+		if selectedValue != nil && o.Value == *selectedValue {
 			p.selectedIndex = i
 			break
 		}
 	}
-	p.term = previousTerm
+	p.term, p.cursor = previousTerm, previousCursor
 	p.windowStart = previousWindowStart
 	p.windowEnd = previousWindowEnd
-	p.render()
+
+	// Keep the window filled and the selection visible if rows were removed
+	if p.windowStart > 0 && p.windowEnd > len(p.filtered) {
+		shift := min(p.windowStart, p.windowEnd-len(p.filtered))
+		p.windowStart -= shift
+		p.windowEnd -= shift
+	}
+	if p.selectedIndex >= 0 && p.selectedIndex < p.windowStart {
+		p.windowStart = p.selectedIndex
+		p.windowEnd = p.windowStart + p.maxHeight
+	}
+	if p.selectedIndex >= p.windowEnd {
+		p.windowEnd = p.selectedIndex + 1
+		p.windowStart = p.windowEnd - p.maxHeight
+	}
+	if p.listening {
+		p.render()
+	}
 }
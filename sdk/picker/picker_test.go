@@ -0,0 +1,289 @@
+package picker
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/null93/aws-knox/pkg/vterm"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const waitTimeout = 2 * time.Second
+
+func newServerPicker() *Picker[string] {
+	p := NewPicker[string]()
+	p.WithTitle("Pick Server")
+	p.WithHeaders("Name", "Region")
+	for i := 1; i <= 12; i++ {
+		name := fmt.Sprintf("web-%d", i)
+		region := []string{"us-east-1", "eu-west-1", "ap-south-1"}[i%3]
+		p.AddOption(name, name, region)
+		p.SetOptionPreview(name, func() []string {
+			return []string{"Name:    " + name, "Region:  " + region}
+		})
+	}
+	return p
+}
+
+// The picker runs in the background and what it picked is sent once it
+// returns, the screen is driven and checked in the meantime.
+func startPicker(t *testing.T, p *Picker[string], many bool) (*vterm.Terminal, <-chan []string) {
+	t.Helper()
+	term := vterm.New(100, 20)
+	t.Cleanup(func() { term.Close() })
+	p.WithInput(NewEventSource(term))
+	p.WithOutput(term)
+	picked := make(chan []string, 1)
+	go func() {
+		values := []string{}
+		if many {
+			options, _ := p.PickMany("")
+			for _, option := range options {
+				values = append(values, option.Value)
+			}
+		} else if option, _ := p.Pick(""); option != nil {
+			values = append(values, option.Value)
+		}
+		picked <- values
+	}()
+	waitFor(t, term, "Pick Server")
+	return term, picked
+}
+
+func waitFor(t *testing.T, term *vterm.Terminal, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		if err := term.WaitFor(text, waitTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func matchGolden(t *testing.T, term *vterm.Terminal, name string) {
+	t.Helper()
+	if err := term.MatchGolden("testdata/"+name+".golden", *update); err != nil {
+		t.Error(err)
+	}
+}
+
+func expectPicked(t *testing.T, picked <-chan []string, expected []string) {
+	t.Helper()
+	select {
+	case values := <-picked:
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("picked %q, want %q", values, expected)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for the picker to return")
+	}
+}
+
+func TestPickerFilter(t *testing.T) {
+	p := newServerPicker()
+	p.WithFilterStrategy("exact")
+	term, picked := startPicker(t, p, false)
+	term.Type("web-1")
+	waitFor(t, term, "filter: web-1█", "4/12 items")
+	matchGolden(t, term, "filter")
+	term.Press(vterm.Backspace)
+	waitFor(t, term, "filter: web-█", "12/12 items")
+	term.Type("x")
+	waitFor(t, term, "Nothing Found")
+	matchGolden(t, term, "filter_empty")
+	term.Press(vterm.Backspace, vterm.Backspace, vterm.Backspace, vterm.Backspace, vterm.Backspace)
+	term.Type("eu")
+	waitFor(t, term, "filter: eu█", "4/12 items")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-1"})
+}
+
+// Terms shorter than the n-grams keep every row, longer ones keep the rows
+// that are at least as similar as the average.
+func TestPickerFuzzyFilter(t *testing.T) {
+	term, picked := startPicker(t, newServerPicker(), false)
+	term.Type("we")
+	waitFor(t, term, "filter: we█", "12/12 items")
+	term.Type("b-1")
+	waitFor(t, term, "filter: web-1█")
+	matchGolden(t, term, "filter_fuzzy")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-1"})
+}
+
+func TestPickerNavigate(t *testing.T) {
+	p := newServerPicker()
+	p.WithPreview(true)
+	term, picked := startPicker(t, p, false)
+	term.Press(vterm.Down, vterm.Down, vterm.PgDown)
+	waitFor(t, term, "Name:    web-8")
+	matchGolden(t, term, "navigate_page")
+	term.Type("\033>")
+	waitFor(t, term, "Name:    web-12")
+	matchGolden(t, term, "navigate_last")
+	term.Press(vterm.Up, vterm.CtrlP)
+	waitFor(t, term, "Name:    web-10")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-10"})
+}
+
+// Home and end move the filter cursor first, and jump rows once it is at
+// that end already.
+func TestPickerHomeEnd(t *testing.T) {
+	p := newServerPicker()
+	p.WithFilterStrategy("exact")
+	p.WithPreview(true)
+	term, picked := startPicker(t, p, false)
+	term.Press(vterm.End)
+	waitFor(t, term, "Name:    web-12")
+	term.Press(vterm.Home)
+	waitFor(t, term, "Name:    web-1\n")
+	term.Type("web")
+	waitFor(t, term, "filter: web█")
+	term.Press(vterm.End)
+	waitFor(t, term, "Name:    web-12")
+	term.Press(vterm.Home)
+	waitFor(t, term, "filter: web\n")
+	matchGolden(t, term, "home_cursor")
+	term.Press(vterm.Home)
+	waitFor(t, term, "Name:    web-1\n")
+	term.Press(vterm.End)
+	waitFor(t, term, "filter: web█", "Name:    web-1\n")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-1"})
+}
+
+func TestPickerGoToRow(t *testing.T) {
+	p := newServerPicker()
+	p.WithPreview(true)
+	term, picked := startPicker(t, p, false)
+	term.Press(vterm.CtrlG)
+	waitFor(t, term, "go to row (1-12): █")
+	term.Type("9")
+	waitFor(t, term, "go to row (1-12): 9█")
+	matchGolden(t, term, "go_to_row")
+	term.Press(vterm.Enter)
+	waitFor(t, term, "Name:    web-9")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-9"})
+}
+
+func TestPickerMultiSelect(t *testing.T) {
+	p := newServerPicker()
+	p.WithFilterStrategy("exact")
+	p.WithMultiSelect()
+	term, picked := startPicker(t, p, true)
+	term.Press(vterm.Space, vterm.Down, vterm.Down, vterm.Tab)
+	waitFor(t, term, "2 selected")
+	matchGolden(t, term, "multi_select")
+	term.Type("eu")
+	waitFor(t, term, "4/12 items")
+	term.Press(vterm.CtrlA)
+	waitFor(t, term, "5 selected")
+	matchGolden(t, term, "multi_select_all")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-1", "web-3", "web-4", "web-7", "web-10"})
+}
+
+func TestPickerSort(t *testing.T) {
+	p := newServerPicker()
+	sorts := []string{}
+	p.OnSortChange(func(sort string) { sorts = append(sorts, sort) })
+	term, picked := startPicker(t, p, false)
+	term.Press(vterm.CtrlS, vterm.CtrlS)
+	waitFor(t, term, "Name ▼")
+	matchGolden(t, term, "sort_name_desc")
+	term.Press(vterm.CtrlS)
+	waitFor(t, term, "Region ▲")
+	matchGolden(t, term, "sort_region")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-1"})
+	expected := []string{"Name:asc", "Name:desc", "Region:asc"}
+	if !reflect.DeepEqual(sorts, expected) {
+		t.Errorf("sorts are %q, want %q", sorts, expected)
+	}
+}
+
+func TestPickerPreview(t *testing.T) {
+	p := newServerPicker()
+	p.WithPreview(false)
+	term, picked := startPicker(t, p, false)
+	term.Press(vterm.CtrlO)
+	waitFor(t, term, "Name:    web-1")
+	matchGolden(t, term, "preview")
+	term.Press(vterm.CtrlC)
+	expectPicked(t, picked, []string{})
+}
+
+// Narrow terminals show the preview below the list instead of beside it.
+func TestPickerPreviewBelow(t *testing.T) {
+	p := newServerPicker()
+	p.WithPreview(true)
+	term := vterm.New(40, 24)
+	t.Cleanup(func() { term.Close() })
+	p.WithInput(NewEventSource(term))
+	p.WithOutput(term)
+	picked := make(chan []string, 1)
+	go func() {
+		option, _ := p.Pick("")
+		picked <- []string{option.Value}
+	}()
+	waitFor(t, term, "Name:    web-1")
+	matchGolden(t, term, "preview_below")
+	term.Press(vterm.Enter)
+	expectPicked(t, picked, []string{"web-1"})
+}
+
+// Rows streamed in while keys are pressed change the picker through Mutate,
+// which the race detector checks when the tests run with -race.
+func TestPickerStreamingMultiSelect(t *testing.T) {
+	p := newServerPicker()
+	p.WithFilterStrategy("exact")
+	p.WithMultiSelect()
+	p.SetLoading(true)
+	term, picked := startPicker(t, p, true)
+	term.Press(vterm.Space)
+	waitFor(t, term, "1 selected")
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		for page := 0; page < 20; page++ {
+			p.Mutate(func() {
+				for i := 0; i < 10; i++ {
+					name := fmt.Sprintf("db-%d", page*10+i)
+					p.AddOption(name, name, "us-east-1")
+				}
+				p.RemoveOption(fmt.Sprintf("web-%d", 2+page%11))
+				p.UpdateOption("web-1", "web-1", "eu-west-1 (updated)")
+			})
+		}
+		p.Mutate(func() {
+			p.SetLoading(false)
+		})
+	}()
+	for i := 0; i < 20; i++ {
+		term.Press(vterm.Down, vterm.Space, vterm.Up, vterm.Down)
+	}
+	<-streamed
+	waitFor(t, term, "201/201 items", "(updated)")
+	term.Type("web")
+	waitFor(t, term, "1/201 items")
+	term.Press(vterm.Enter)
+	select {
+	case values := <-picked:
+		if len(values) < 1 || values[0] != "web-1" {
+			t.Errorf("picked %q, want web-1 first", values)
+		}
+		for _, value := range values[1:] {
+			if value == "web-1" || !strings.HasPrefix(value, "db-") {
+				t.Errorf("picked %q, which was removed or picked twice", value)
+			}
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for the picker to return")
+	}
+}
@@ -0,0 +1,129 @@
+package picker
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/null93/aws-knox/pkg/color"
+	. "github.com/null93/aws-knox/sdk/style"
+	"golang.org/x/term"
+)
+
+const (
+	previewGap      = " │ "
+	previewMinWidth = 30
+	fallbackWidth   = 80
+)
+
+var ansiPattern = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// The preview of the highlighted option is shown beside the list when the
+// terminal is wide enough and below it otherwise, ctrl+o toggles it.
+func (p *Picker[T]) WithPreview(visible bool) {
+	p.previewEnabled = true
+	p.previewVisible = visible
+}
+
+func (p *Picker[T]) SetOptionPreview(value T, preview func() []string) {
+	for i := range p.options {
+		if p.options[i].Value == value {
+			p.options[i].Preview = preview
+		}
+	}
+}
+
+// Outputs that aren't a terminal can report their own size, like a
+// vterm.Terminal does.
+func (p *Picker[T]) terminalWidth() int {
+	switch out := p.output().(type) {
+	case *os.File:
+		if width, _, err := term.GetSize(int(out.Fd())); err == nil && width > 0 {
+			return width
+		}
+	case interface{ Size() (int, int) }:
+		if width, _ := out.Size(); width > 0 {
+			return width
+		}
+	}
+	return fallbackWidth
+}
+
+func visibleWidth(line string) int {
+	return runewidth.StringWidth(ansiPattern.ReplaceAllString(line, ""))
+}
+
+func (p *Picker[T]) wrappedLines(line string) int {
+	width := p.terminalWidth()
+	return max((visibleWidth(line)+width-1)/width, 1)
+}
+
+func (p *Picker[T]) listWidth() int {
+	width := 0
+	if p.multiSelect {
+		width += 3
+	}
+	for i := 0; i < max(len(p.headers), len(p.longestCols)); i++ {
+		width += p.colWidth(i) + 2
+	}
+	return width
+}
+
+func (p *Picker[T]) preview() ([]string, bool) {
+	option := p.highlighted()
+	if !p.previewVisible || option == nil || option.Preview == nil {
+		return nil, false
+	}
+	lines := option.Preview()
+	if len(lines) < 1 {
+		return nil, false
+	}
+	lightGray := color.ToForeground(LightGrayColor).Decorator()
+	darkGray := color.ToForeground(DarkGrayColor).Decorator()
+	width := p.terminalWidth()
+	listWidth := p.listWidth()
+	beside := listWidth+runewidth.StringWidth(previewGap)+previewMinWidth < width
+	height := max(p.maxHeight, 1)
+	lineWidth := width - 2
+	if beside {
+		height++
+		lineWidth = width - listWidth - runewidth.StringWidth(previewGap) - 1
+	}
+	// Callers may cache the lines they return, so they are cut off in a copy.
+	if len(lines) > height {
+		lines = append(slices.Clone(lines[:height-1]), "…")
+	}
+	styled := []string{}
+	if !beside {
+		styled = append(styled, darkGray(" %s", strings.Repeat("─", max(min(listWidth, lineWidth), 1))))
+	}
+	for _, line := range lines {
+		line = runewidth.Truncate(strings.ReplaceAll(line, "\t", " "), lineWidth, "…")
+		if beside {
+			styled = append(styled, lightGray("%s", line))
+		} else {
+			styled = append(styled, lightGray(" %s", line))
+		}
+	}
+	return styled, beside
+}
+
+func (p *Picker[T]) besidePreview(rows []string, preview []string) []string {
+	darkGray := color.ToForeground(DarkGrayColor).Decorator()
+	listWidth := p.listWidth()
+	combined := []string{}
+	for i := 0; i < max(len(rows), len(preview)); i++ {
+		row := ""
+		if i < len(rows) {
+			row = rows[i]
+		}
+		row += strings.Repeat(" ", max(listWidth-visibleWidth(row), 0))
+		if i < len(preview) {
+			row += darkGray(previewGap) + preview[i]
+		}
+		combined = append(combined, row)
+	}
+	return combined
+}
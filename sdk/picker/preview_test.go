@@ -0,0 +1,39 @@
+package picker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/null93/aws-knox/pkg/vterm"
+)
+
+func TestPreviewCutOff(t *testing.T) {
+	lines := []string{"one", "two", "three", "four", "five", "six", "seven"}
+	cached := append([]string{}, lines...)
+	for _, maxHeight := range []int{0, 1, 3, 10} {
+		p := NewPicker[string]()
+		p.WithMaxHeight(maxHeight)
+		p.WithPreview(true)
+		p.WithOutput(vterm.New(20, 10))
+		p.AddOption("a", "a")
+		p.SetOptionPreview("a", func() []string { return cached })
+		p.filter()
+		preview, beside := p.preview()
+		if beside {
+			t.Fatalf("max height %d: preview is beside a list of %d columns", maxHeight, p.listWidth())
+		}
+		// The first line is the separator below the list.
+		height := max(maxHeight, 1)
+		expected := min(len(lines), height)
+		if len(preview)-1 != expected {
+			t.Errorf("max height %d: preview has %d lines, want %d", maxHeight, len(preview)-1, expected)
+		}
+		if len(lines) > height && !strings.Contains(preview[len(preview)-1], "…") {
+			t.Errorf("max height %d: cut off preview does not end with …", maxHeight)
+		}
+		if !reflect.DeepEqual(cached, lines) {
+			t.Fatalf("max height %d: the preview lines were changed to %q", maxHeight, cached)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package picker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// Sorts are written as "<header>:<asc|desc>" so they survive columns being
+// added or reordered, an empty string means the filter order is kept.
+func (p *Picker[T]) WithSort(sort string) {
+	p.sortColumn = -1
+	p.sortDescending = false
+	header, direction, _ := strings.Cut(sort, ":")
+	for i, h := range p.headers {
+		if strings.EqualFold(h, strings.TrimSpace(header)) {
+			p.sortColumn = i
+			p.sortDescending = strings.EqualFold(strings.TrimSpace(direction), SortDescending)
+		}
+	}
+}
+
+func (p *Picker[T]) OnSortChange(callback func(sort string)) {
+	p.onSortChange = callback
+}
+
+func (p *Picker[T]) Sort() string {
+	if p.sortColumn < 0 || p.sortColumn >= len(p.headers) {
+		return ""
+	}
+	if p.sortDescending {
+		return fmt.Sprintf("%s:%s", p.headers[p.sortColumn], SortDescending)
+	}
+	return fmt.Sprintf("%s:%s", p.headers[p.sortColumn], SortAscending)
+}
+
+// Sort keys are compared instead of the displayed columns, for columns like
+// "3 days ago" that don't sort the way they read.
+func (p *Picker[T]) SetOptionSortKeys(value T, keys ...string) {
+	for i := range p.options {
+		if p.options[i].Value == value {
+			p.options[i].SortKeys = keys
+		}
+	}
+}
+
+// Each column is sorted ascending, then descending, before moving on to the
+// next one, and after the last column the filter order is restored.
+func (p *Picker[T]) cycleSort() {
+	switch {
+	case p.sortColumn < 0:
+		p.sortColumn, p.sortDescending = 0, false
+	case !p.sortDescending:
+		p.sortDescending = true
+	case p.sortColumn < len(p.headers)-1:
+		p.sortColumn, p.sortDescending = p.sortColumn+1, false
+	default:
+		p.sortColumn, p.sortDescending = -1, false
+	}
+	if p.onSortChange != nil {
+		p.onSortChange(p.Sort())
+	}
+}
+
+func (p *Picker[T]) sortIndicator(column int) string {
+	if column != p.sortColumn {
+		return ""
+	}
+	if p.sortDescending {
+		return " ▼"
+	}
+	return " ▲"
+}
+
+func (o *Option[T]) sortKey(column int) string {
+	if column < len(o.SortKeys) && o.SortKeys[column] != "" {
+		return o.SortKeys[column]
+	}
+	if column < len(o.Columns) {
+		return o.Columns[column]
+	}
+	return ""
+}
+
+// Rows without a value for the column stay at the bottom in either direction.
+func (p *Picker[T]) sort() {
+	if p.sortColumn < 0 || p.sortColumn >= len(p.headers) {
+		return
+	}
+	sort.SliceStable(p.filtered, func(i, j int) bool {
+		a, b := p.filtered[i].sortKey(p.sortColumn), p.filtered[j].sortKey(p.sortColumn)
+		aEmpty, bEmpty := isEmptyValue(a), isEmptyValue(b)
+		if aEmpty || bEmpty {
+			return !aEmpty && bEmpty
+		}
+		if p.sortDescending {
+			return naturalLess(b, a)
+		}
+		return naturalLess(a, b)
+	})
+}
+
+func isEmptyValue(value string) bool {
+	value = strings.TrimSpace(value)
+	return value == "" || value == "-"
+}
+
+// Runs of digits are compared by their numeric value, so "9 mins" comes
+// before "10 mins" and "web-2" before "web-10".
+func naturalLess(a, b string) bool {
+	ar, br := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		if unicode.IsDigit(ar[i]) && unicode.IsDigit(br[j]) {
+			si, sj := i, j
+			for i < len(ar) && unicode.IsDigit(ar[i]) {
+				i++
+			}
+			for j < len(br) && unicode.IsDigit(br[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ar[si:i]), "0")
+			nb := strings.TrimLeft(string(br[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ar[i] != br[j] {
+			return ar[i] < br[j]
+		}
+		i++
+		j++
+	}
+	return len(ar)-i < len(br)-j
+}
@@ -0,0 +1,100 @@
+package picker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{"web-2", "web-10", true},
+		{"web-10", "web-2", false},
+		{"9 mins", "10 mins", true},
+		{"web-02", "web-10", true},
+		{"web-007", "web-7", false},
+		{"web-7", "web-007", false},
+		{"Alpha", "beta", true},
+		{"alpha", "Beta", true},
+		{"ALPHA", "alpha", false},
+		{"web", "web-1", true},
+		{"web-1", "web", false},
+		{"", "a", true},
+		{"a", "", false},
+		{"1.10.0", "1.9.0", false},
+		{"1.9.0", "1.10.0", true},
+		{"a1b2", "a1b10", true},
+		{"99999999999999999999", "100000000000000000000", true},
+		{"ä", "z", false},
+	}
+	for _, test := range tests {
+		if less := naturalLess(test.a, test.b); less != test.less {
+			t.Errorf("naturalLess(%q, %q) is %t, want %t", test.a, test.b, less, test.less)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		sort     string
+		expected []string
+	}{
+		{"", []string{"web-10", "web-2", "-", "", "web-1"}},
+		{"Name:asc", []string{"web-1", "web-2", "web-10", "-", ""}},
+		{"Name:desc", []string{"web-10", "web-2", "web-1", "-", ""}},
+		{"name:DESC", []string{"web-10", "web-2", "web-1", "-", ""}},
+		{"Missing:asc", []string{"web-10", "web-2", "-", "", "web-1"}},
+	}
+	for _, test := range tests {
+		p := NewPicker[string]()
+		p.WithHeaders("Name")
+		p.WithSort(test.sort)
+		for _, name := range []string{"web-10", "web-2", "-", "", "web-1"} {
+			p.AddOption(name, name)
+		}
+		p.filter()
+		names := []string{}
+		for _, option := range p.filtered {
+			names = append(names, option.Value)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%q: sorted to %q, want %q", test.sort, names, test.expected)
+		}
+	}
+}
+
+// Sort keys are compared instead of the columns when they are set.
+func TestSortKeys(t *testing.T) {
+	p := NewPicker[string]()
+	p.WithHeaders("Name", "Last Ping")
+	p.WithSort("Last Ping:asc")
+	p.AddOption("a", "a", "2 days ago")
+	p.AddOption("b", "b", "5 mins ago")
+	p.AddOption("c", "c", "-")
+	p.SetOptionSortKeys("a", "", "172800")
+	p.SetOptionSortKeys("b", "", "300")
+	p.filter()
+	names := []string{}
+	for _, option := range p.filtered {
+		names = append(names, option.Value)
+	}
+	if !reflect.DeepEqual(names, []string{"b", "a", "c"}) {
+		t.Errorf("sorted to %q", names)
+	}
+}
+
+func TestCycleSort(t *testing.T) {
+	p := NewPicker[string]()
+	p.WithHeaders("Name", "Region")
+	changes := []string{}
+	p.OnSortChange(func(sort string) { changes = append(changes, sort) })
+	for i := 0; i < 5; i++ {
+		p.cycleSort()
+	}
+	expected := []string{"Name:asc", "Name:desc", "Region:asc", "Region:desc", ""}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("sort changes are %q, want %q", changes, expected)
+	}
+}
@@ -0,0 +1,97 @@
+package picker
+
+import (
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+)
+
+func pad(value string, width int) string {
+	return runewidth.FillRight(value, width)
+}
+
+func (p *Picker[T]) growCols(cols []string) {
+	for i, col := range cols {
+		if len(p.longestCols) <= i {
+			p.longestCols = append(p.longestCols, 0)
+		}
+		if width := runewidth.StringWidth(col); width > p.longestCols[i] {
+			p.longestCols[i] = width
+		}
+	}
+}
+
+func (p *Picker[T]) setTerm(term string) {
+	p.term = []rune(term)
+	p.cursor = len(p.term)
+}
+
+// Pasted text arrives as a single key with many runes, line breaks and other
+// control characters are dropped so a pasted line can be filtered on.
+func (p *Picker[T]) insert(runes []rune) bool {
+	inserted := []rune{}
+	for _, r := range runes {
+		if !unicode.IsControl(r) {
+			inserted = append(inserted, r)
+		}
+	}
+	if len(inserted) < 1 {
+		return false
+	}
+	term := append([]rune{}, p.term[:p.cursor]...)
+	term = append(term, inserted...)
+	p.term = append(term, p.term[p.cursor:]...)
+	p.cursor += len(inserted)
+	return true
+}
+
+func (p *Picker[T]) deleteBack(from int) bool {
+	if from < 0 || from >= p.cursor {
+		return false
+	}
+	p.term = append(p.term[:from], p.term[p.cursor:]...)
+	p.cursor = from
+	return true
+}
+
+func (p *Picker[T]) wordStart() int {
+	i := p.cursor
+	for i > 0 && unicode.IsSpace(p.term[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(p.term[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (p *Picker[T]) wordEnd() int {
+	i := p.cursor
+	for i < len(p.term) && unicode.IsSpace(p.term[i]) {
+		i++
+	}
+	for i < len(p.term) && !unicode.IsSpace(p.term[i]) {
+		i++
+	}
+	return i
+}
+
+func (p *Picker[T]) moveCursor(to int) bool {
+	to = max(0, min(to, len(p.term)))
+	if to == p.cursor {
+		return false
+	}
+	p.cursor = to
+	return true
+}
+
+func (p *Picker[T]) colWidth(column int) int {
+	width := 0
+	if column < len(p.longestCols) {
+		width = p.longestCols[column]
+	}
+	if column < len(p.headers) {
+		width = max(width, runewidth.StringWidth(p.headers[column]+p.sortIndicator(column)))
+	}
+	return width
+}
@@ -0,0 +1,278 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const (
+	CastExtension     = ".cast"
+	MetadataExtension = ".json"
+)
+
+type Metadata struct {
+	Name        string    `json:"name"`
+	SessionName string    `json:"sessionName"`
+	AccountId   string    `json:"accountId"`
+	RoleName    string    `json:"roleName"`
+	InstanceId  string    `json:"instanceId"`
+	Region      string    `json:"region"`
+	SessionId   string    `json:"sessionId"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end,omitempty"`
+}
+
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes an asciinema v2 cast, one JSON event per line, next to a
+// metadata file that is rewritten once the session ends.
+type Recorder struct {
+	Metadata Metadata
+	dir      string
+	file     *os.File
+	pending  []byte
+	mutex    sync.Mutex
+}
+
+func (m Metadata) Duration() time.Duration {
+	if m.End.IsZero() {
+		return 0
+	}
+	return m.End.Sub(m.Start)
+}
+
+func (m Metadata) CastPath(dir string) string {
+	return filepath.Join(dir, m.Name+CastExtension)
+}
+
+func New(dir string, metadata Metadata, width, height int) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	metadata.Start = time.Now()
+	metadata.Name = fmt.Sprintf("%s-%s", metadata.Start.Format("20060102-150405"), metadata.InstanceId)
+	file, err := os.OpenFile(metadata.CastPath(dir), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{Metadata: metadata, dir: dir, file: file}
+	encoded, err := json.Marshal(header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: metadata.Start.Unix(),
+		Title:     fmt.Sprintf("%s (%s)", metadata.InstanceId, metadata.AccountId),
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	})
+	if err == nil {
+		_, err = fmt.Fprintf(file, "%s\n", encoded)
+	}
+	if err == nil {
+		err = r.saveMetadata()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) saveMetadata() error {
+	encoded, err := json.MarshalIndent(r.Metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.dir, r.Metadata.Name+MetadataExtension), encoded, 0600)
+}
+
+func (r *Recorder) event(kind, data string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	encoded, err := json.Marshal([]any{time.Since(r.Metadata.Start).Seconds(), kind, data})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(r.file, "%s\n", encoded)
+	return err
+}
+
+// Reads can split a multi-byte character, which json.Marshal would replace
+// with U+FFFD, so an incomplete trailing character waits for the next write.
+func (r *Recorder) Write(p []byte) (int, error) {
+	data := append(r.pending, p...)
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte{}, data[complete:]...)
+	if complete == 0 {
+		return len(p), nil
+	}
+	if err := r.event("o", string(data[:complete])); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *Recorder) Resize(width, height int) error {
+	return r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// WatchResize records a resize event whenever the terminal behind fd changes
+// size, until the returned function is called.
+func (r *Recorder) WatchResize(fd int) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				if width, height, err := term.GetSize(fd); err == nil {
+					r.Resize(width, height)
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func (r *Recorder) Close() error {
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Metadata.End = time.Now()
+	err := r.saveMetadata()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Discard closes the recording and removes it, for sessions that turned out
+// not to be recordable.
+func (r *Recorder) Discard() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.file.Close()
+	os.Remove(filepath.Join(r.dir, r.Metadata.Name+MetadataExtension))
+	return os.Remove(r.Metadata.CastPath(r.dir))
+}
+
+func List(dir string) ([]Metadata, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Metadata{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordings := []Metadata{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != MetadataExtension {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		metadata := Metadata{}
+		if json.Unmarshal(contents, &metadata) == nil && metadata.Name != "" {
+			recordings = append(recordings, metadata)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Start.After(recordings[j].Start)
+	})
+	return recordings, nil
+}
+
+// Play writes the recorded output with its original timing, scaled by speed.
+// Pauses longer than idleLimit are shortened to it when idleLimit is positive.
+func Play(ctx context.Context, path string, output io.Writer, speed float64, idleLimit time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if speed <= 0 {
+		speed = 1
+	}
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("empty recording")
+	}
+	recorded := header{}
+	if err := json.Unmarshal([]byte(line), &recorded); err != nil || recorded.Version != 2 {
+		return fmt.Errorf("unsupported recording format")
+	}
+	previous := 0.0
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			event := []any{}
+			if jsonErr := json.Unmarshal([]byte(line), &event); jsonErr != nil || len(event) != 3 {
+				return fmt.Errorf("malformed event: %q", strings.TrimSpace(line))
+			}
+			at, _ := event[0].(float64)
+			kind, _ := event[1].(string)
+			data, _ := event[2].(string)
+			delay := time.Duration((at - previous) / speed * float64(time.Second))
+			if idleLimit > 0 && delay > idleLimit {
+				delay = idleLimit
+			}
+			previous = at
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			if kind == "o" {
+				if _, err := io.WriteString(output, data); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/null93/aws-knox/sdk/channel"
+)
+
+// Native speaks the data channel protocol directly, and hands sessions it
+// cannot handle, like KMS encrypted ones, to the fallback runner if any.
+type Native struct {
+	Fallback Runner
+}
+
+func (n *Native) shouldFallback(err error) bool {
+	return n.Fallback != nil && errors.Is(err, channel.ErrEncryptionNotSupported)
+}
+
+// Port sessions are served one connection at a time by the native client,
+// while the plugin multiplexes them, so they go to the plugin when it is
+// installed.
+func (n *Native) shouldDelegate(request Request) bool {
+	if request.Mode != ModePort || n.Fallback == nil {
+		return false
+	}
+	if available, ok := n.Fallback.(interface{ Available() bool }); ok {
+		return available.Available()
+	}
+	return true
+}
+
+func (n *Native) Run(ctx context.Context, request Request) (*Result, error) {
+	if n.shouldDelegate(request) {
+		return n.Fallback.Run(ctx, request)
+	}
+	request.defaults()
+	details, err := request.start()
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{SessionId: *details.SessionId, ExitCode: -1}
+	c, err := channel.Open(ctx, *details.StreamUrl, *details.TokenValue)
+	if n.shouldFallback(err) {
+		request.terminate(result.SessionId)
+		return n.Fallback.Run(ctx, request)
+	}
+	if err != nil {
+		request.terminate(result.SessionId)
+		return nil, err
+	}
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	switch request.Mode {
+	case ModeShell:
+		c.Stderr = request.Stderr
+		stdin, ok := request.Stdin.(*os.File)
+		if !ok {
+			stdin = os.Stdin
+		}
+		err = c.Shell(stdin, request.Stdout)
+	case ModeCommand:
+		if request.Capture {
+			result.Output, err = c.Output()
+		} else {
+			var output bytes.Buffer
+			err = c.Pipe(request.Stdin, io.MultiWriter(request.Stdout, &output))
+			result.Output = output.Bytes()
+		}
+		result.parseExitCode()
+	case ModePipe:
+		err = c.Pipe(request.Stdin, request.Stdout)
+	case ModePort:
+		err = n.forward(ctx, c, request)
+	}
+	if n.shouldFallback(err) {
+		request.terminate(result.SessionId)
+		return n.Fallback.Run(ctx, request)
+	}
+	if ctx.Err() != nil {
+		result.Aborted = true
+		request.terminate(result.SessionId)
+		return result, nil
+	}
+	if err != nil {
+		request.terminate(result.SessionId)
+	}
+	return result, err
+}
+
+func (n *Native) forward(ctx context.Context, c *channel.Channel, request Request) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(request.LocalPort))))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	request.OnEvent(EventWaiting)
+	return c.Forward(listener, func() { request.OnEvent(EventAccepted) })
+}
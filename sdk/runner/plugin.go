@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const PluginBinary = "session-manager-plugin"
+
+type Plugin struct {
+	BinaryPath string
+}
+
+type pluginSession struct {
+	SessionId  string `json:"SessionId"`
+	TokenValue string `json:"TokenValue"`
+	StreamUrl  string `json:"StreamUrl"`
+}
+
+type pluginParameters struct {
+	Target string `json:"Target"`
+}
+
+func (p *Plugin) binary() string {
+	if p.BinaryPath != "" {
+		return p.BinaryPath
+	}
+	return PluginBinary
+}
+
+func (p *Plugin) Available() bool {
+	_, err := exec.LookPath(p.binary())
+	return err == nil
+}
+
+func (p *Plugin) command(binaryPath string, request Request, sessionId, tokenValue, streamUrl string) (*exec.Cmd, error) {
+	session, err := json.Marshal(pluginSession{sessionId, tokenValue, streamUrl})
+	if err != nil {
+		return nil, err
+	}
+	parameters, err := json.Marshal(pluginParameters{request.Target})
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(
+		binaryPath,
+		string(session),
+		request.Region,
+		"StartSession",
+		"", // No Profile
+		string(parameters),
+		fmt.Sprintf("https://ssm.%s.amazonaws.com", request.Region),
+	), nil
+}
+
+// Interactive modes hand the terminal to the plugin, so ctrl+c and window
+// size changes reach it directly. Otherwise they are forwarded from here.
+func forwardSignals(process *os.Process, foreground bool) func() {
+	signals := make(chan os.Signal, 1)
+	if foreground {
+		signal.Notify(signals, syscall.SIGTERM)
+	} else {
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGWINCH)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				process.Signal(sig)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func (p *Plugin) Run(ctx context.Context, request Request) (*Result, error) {
+	request.defaults()
+	// The plugin is looked up before a session is started, so a missing
+	// plugin doesn't leave a session behind.
+	binaryPath, err := exec.LookPath(p.binary())
+	if err != nil {
+		return nil, err
+	}
+	details, err := request.start()
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{SessionId: *details.SessionId, ExitCode: -1}
+	command, err := p.command(binaryPath, request, *details.SessionId, *details.TokenValue, *details.StreamUrl)
+	if err != nil {
+		request.terminate(result.SessionId)
+		return nil, err
+	}
+
+	foreground := false
+	var output bytes.Buffer
+	var stdout io.ReadCloser
+	switch request.Mode {
+	case ModeShell:
+		foreground = true
+		command.Stdin, command.Stdout, command.Stderr = request.Stdin, request.Stdout, request.Stderr
+	case ModeCommand:
+		foreground = true
+		command.Stdin = request.Stdin
+		command.Stdout, command.Stderr = request.Stdout, request.Stderr
+		if request.Capture {
+			command.Stdout, command.Stderr = &output, &output
+		}
+	case ModePipe:
+		command.Stdin, command.Stdout, command.Stderr = request.Stdin, request.Stdout, request.Stderr
+	case ModePort:
+		if stdout, err = command.StdoutPipe(); err != nil {
+			request.terminate(result.SessionId)
+			return nil, err
+		}
+	}
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: request.Mode != ModePipe, Foreground: foreground}
+	if err = command.Start(); err != nil {
+		request.terminate(result.SessionId)
+		return nil, err
+	}
+	stopForwarding := forwardSignals(command.Process, foreground)
+	defer stopForwarding()
+	stopInterrupt := context.AfterFunc(ctx, func() {
+		command.Process.Signal(os.Interrupt)
+		time.AfterFunc(interruptTimeout, func() { command.Process.Kill() })
+	})
+	defer stopInterrupt()
+
+	if stdout != nil {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "Waiting for connections") {
+				request.OnEvent(EventWaiting)
+			} else if strings.HasPrefix(line, "Connection accepted") {
+				request.OnEvent(EventAccepted)
+			}
+		}
+	}
+	err = command.Wait()
+	result.Output = output.Bytes()
+	if request.Mode == ModeCommand {
+		result.parseExitCode()
+	}
+	if ctx.Err() != nil {
+		result.Aborted = true
+		request.terminate(result.SessionId)
+		return result, nil
+	}
+	if err != nil {
+		request.terminate(result.SessionId)
+		return result, err
+	}
+	return result, nil
+}
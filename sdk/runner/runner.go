@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+type Mode int
+
+const (
+	ModeShell Mode = iota
+	ModeCommand
+	ModePipe
+	ModePort
+)
+
+type Event string
+
+const (
+	EventWaiting  Event = "waiting"
+	EventAccepted Event = "accepted"
+)
+
+const (
+	ExitCodeMarker   = "KNOX_EXIT_CODE"
+	terminateTimeout = 10 * time.Second
+	interruptTimeout = 5 * time.Second
+)
+
+var (
+	ErrStartSession = errors.New("failed to start ssm session")
+	exitCodeLine    = regexp.MustCompile(ExitCodeMarker + `: (\d+)\r?\n?`)
+)
+
+type Starter func() (*ssm.StartSessionOutput, error)
+
+type Terminator func(ctx context.Context, sessionId string) error
+
+type Request struct {
+	Mode      Mode
+	Region    string
+	Target    string
+	Start     Starter
+	Terminate Terminator
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	Capture   bool
+	LocalPort uint16
+	OnEvent   func(Event)
+}
+
+type Result struct {
+	SessionId string
+	Output    []byte
+	ExitCode  int
+	Aborted   bool
+}
+
+type Runner interface {
+	Run(ctx context.Context, request Request) (*Result, error)
+}
+
+// Script runs a shell script as root on the instance with the given shell and
+// reports its exit code. It is piped through base64 so that variables and
+// substitutions are expanded by that shell rather than by the session's shell.
+// The wrapper itself is POSIX, so scripts meant for minimal images without
+// bash can be run with sh.
+func Script(shell, script string) string {
+	wrapped := fmt.Sprintf("( %s\n); echo \"%s: $?\"", script, ExitCodeMarker)
+	return fmt.Sprintf("echo %s | base64 -d | %s", base64.StdEncoding.EncodeToString([]byte(wrapped)), shell)
+}
+
+func (r *Request) defaults() {
+	if r.Stdin == nil {
+		r.Stdin = os.Stdin
+	}
+	if r.Stdout == nil {
+		r.Stdout = os.Stdout
+	}
+	if r.Stderr == nil {
+		r.Stderr = os.Stderr
+	}
+	if r.OnEvent == nil {
+		r.OnEvent = func(Event) {}
+	}
+}
+
+func (r *Request) start() (*ssm.StartSessionOutput, error) {
+	details, err := r.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStartSession, err)
+	}
+	return details, nil
+}
+
+func (r *Request) terminate(sessionId string) {
+	if r.Terminate == nil || sessionId == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), terminateTimeout)
+	defer cancel()
+	r.Terminate(ctx, sessionId)
+}
+
+// The exit code marker printed by Script is stripped from captured output.
+// It is not anchored to the start of a line, since output that does not end
+// with a newline runs into it, and the last one wins over any printed by
+// the script itself.
+func (r *Result) parseExitCode() {
+	r.ExitCode = -1
+	matches := exitCodeLine.FindAllSubmatchIndex(r.Output, -1)
+	if len(matches) < 1 {
+		return
+	}
+	last := matches[len(matches)-1]
+	if code, err := strconv.Atoi(string(r.Output[last[2]:last[3]])); err == nil {
+		r.ExitCode = code
+	}
+	r.Output = append(r.Output[:last[0]:last[0]], r.Output[last[1]:]...)
+}
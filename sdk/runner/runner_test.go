@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		exitCode int
+		stripped string
+	}{
+		{"only marker", "KNOX_EXIT_CODE: 0\n", 0, ""},
+		{"after output", "hello\nKNOX_EXIT_CODE: 3\n", 3, "hello\n"},
+		{"in the middle", "hello\nKNOX_EXIT_CODE: 1\n\nExiting session with sessionId: abc.\n", 1, "hello\n\nExiting session with sessionId: abc.\n"},
+		{"crlf", "hello\r\nKNOX_EXIT_CODE: 2\r\n", 2, "hello\r\n"},
+		{"without trailing newline", "hello\nKNOX_EXIT_CODE: 4", 4, "hello\n"},
+		{"output without newline", "helloKNOX_EXIT_CODE: 5\n", 5, "hello"},
+		{"missing", "hello\n", -1, "hello\n"},
+		{"empty", "", -1, ""},
+		{"multiple", "KNOX_EXIT_CODE: 7\nmore\nKNOX_EXIT_CODE: 0\n", 0, "KNOX_EXIT_CODE: 7\nmore\n"},
+		{"not a number", "KNOX_EXIT_CODE: x\n", -1, "KNOX_EXIT_CODE: x\n"},
+	}
+	for _, test := range tests {
+		result := &Result{Output: []byte(test.output)}
+		result.parseExitCode()
+		if result.ExitCode != test.exitCode {
+			t.Errorf("%s: exit code is %d, want %d", test.name, result.ExitCode, test.exitCode)
+		}
+		if string(result.Output) != test.stripped {
+			t.Errorf("%s: output is %q, want %q", test.name, result.Output, test.stripped)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	if _, err := exec.LookPath("base64"); err != nil {
+		t.Skip("base64 is not installed")
+	}
+	tests := []struct {
+		script   string
+		exitCode int
+		output   string
+	}{
+		{"echo hello", 0, "hello\n"},
+		{"printf 'no newline'; exit 3", 3, "no newline"},
+		{`x='$HOME'; echo "$x"; false`, 1, "$HOME\n"},
+		{"echo 'KNOX_EXIT_CODE: 9'; exit 2", 2, "KNOX_EXIT_CODE: 9\n"},
+	}
+	for _, shell := range []string{"bash", "sh"} {
+		if _, err := exec.LookPath(shell); err != nil {
+			t.Logf("%s is not installed", shell)
+			continue
+		}
+		for _, test := range tests {
+			command := Script(shell, test.script)
+			if strings.Contains(command, ExitCodeMarker) {
+				t.Errorf("%q: marker is not encoded: %s", test.script, command)
+			}
+			if !strings.HasSuffix(command, "| base64 -d | "+shell) {
+				t.Errorf("%q: not run with %s: %s", test.script, shell, command)
+			}
+			output, err := exec.Command("sh", "-c", command).Output()
+			if err != nil {
+				t.Fatalf("%s %q: %v", shell, test.script, err)
+			}
+			result := &Result{Output: output}
+			result.parseExitCode()
+			if result.ExitCode != test.exitCode || string(result.Output) != test.output {
+				t.Errorf("%s %q: got %d %q, want %d %q", shell, test.script, result.ExitCode, result.Output, test.exitCode, test.output)
+			}
+		}
+	}
+}
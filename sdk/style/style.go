@@ -8,6 +8,8 @@ var (
 	YellowColor    = color.FromHex(0xFF9C0A)
 	LightGrayColor = color.FromHex(0x909090)
 	DarkGrayColor  = color.FromHex(0x606060)
+	GreenColor     = color.FromHex(0x3FB950)
+	RedColor       = color.FromHex(0xF85149)
 	BlackColor     = color.FromHex(0x000000)
 )
 
@@ -17,7 +19,9 @@ var (
 	SubTitleStyle        = color.NewStyle().WithForeground(LightGrayColor)
 	HeaderStyle          = color.NewStyle().WithBold(true)
 	OptionStyle          = color.NewStyle()
+	DimmedOptionStyle    = color.NewStyle().WithForeground(DarkGrayColor)
 	HighlightOptionStyle = color.NewStyle().WithForeground(BlackColor).WithBackground(YellowColor).WithBold(true)
 	SearchTermStyle      = color.NewStyle()
 	CursorStyle          = color.NewStyle().WithForeground(YellowColor).WithBlink(true)
+	TermCursorStyle      = color.NewStyle().WithInverse(true)
 )
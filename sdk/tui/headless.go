@@ -0,0 +1,26 @@
+package tui
+
+import (
+	"io"
+
+	"github.com/null93/aws-knox/pkg/ansi"
+	"github.com/null93/aws-knox/pkg/color"
+	"github.com/null93/aws-knox/sdk/picker"
+)
+
+// Headless draws every picker on the given terminal and reads their keys from
+// it, like a vterm.Terminal, so select and connect flows can be driven from a
+// script. The returned func puts the previous output and input back.
+func Headless(terminal io.ReadWriter) func() {
+	ansiWriter, colorWriter, defaultInput := ansi.Writer, color.Writer, picker.DefaultInput
+	ansi.Writer, color.Writer = terminal, terminal
+	// Pickers shown one after another share the source, so none of them
+	// reads input meant for the next.
+	input := picker.NewEventSource(terminal)
+	picker.DefaultInput = func() (picker.EventSource, error) {
+		return input, nil
+	}
+	return func() {
+		ansi.Writer, color.Writer, picker.DefaultInput = ansiWriter, colorWriter, defaultInput
+	}
+}
@@ -1,8 +1,12 @@
 package tui
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"atomicgo.dev/keyboard/keys"
@@ -15,15 +19,41 @@ import (
 )
 
 var (
-	MaxItemsToShow              int    = 10
-	FilterStrategy              string = "fuzzy"
-	ErrNotPickedSession         error  = fmt.Errorf("no sso session picked")
-	ErrNotPickedAccount         error  = fmt.Errorf("no account picked")
-	ErrNotPickedRole            error  = fmt.Errorf("no role picked")
-	ErrNotPickedInstance        error  = fmt.Errorf("no instance picked")
-	ErrNotPickedRoleCredentials error  = fmt.Errorf("no role credentials picked")
+	MaxItemsToShow              int               = 10
+	MaxConcurrentRequests       int               = 8
+	HideOfflineInstances        bool              = false
+	InstanceCacheTTL            time.Duration     = 5 * time.Minute
+	FilterStrategy              string            = "fuzzy"
+	ShowPreview                 bool              = false
+	MouseSupport                bool              = false
+	ErrNotPickedSession         error             = fmt.Errorf("no sso session picked")
+	ErrNotPickedAccount         error             = fmt.Errorf("no account picked")
+	ErrNotPickedRole            error             = fmt.Errorf("no role picked")
+	ErrNotPickedInstance        error             = fmt.Errorf("no instance picked")
+	ErrNotPickedRegion          error             = fmt.Errorf("no region picked")
+	ErrNotPickedRoleCredentials error             = fmt.Errorf("no role credentials picked")
+	PickerSorts                 map[string]string = map[string]string{}
+	SavePickerSort              func(title string, sort string)
 )
 
+type sortable interface {
+	WithSort(sort string)
+	OnSortChange(callback func(sort string))
+}
+
+// Sorts are remembered per picker title, titles that list regions are passed
+// without them so the sort applies whichever regions are searched.
+func withSavedSort(p sortable, title string) {
+	title = strings.ToLower(title)
+	p.WithSort(PickerSorts[title])
+	p.OnSortChange(func(sort string) {
+		PickerSorts[title] = sort
+		if SavePickerSort != nil {
+			SavePickerSort(title, sort)
+		}
+	})
+}
+
 func ClientLogin(session *credentials.Session) error {
 	attemptReauth := false
 	if session.ClientCredentials != nil && !session.ClientCredentials.IsExpired() {
@@ -72,12 +102,14 @@ func ClientLogin(session *credentials.Session) error {
 
 func SelectSession(sessions credentials.Sessions) (string, string, error) {
 	now := time.Now()
-	p := picker.NewPicker()
+	p := picker.NewPicker[string]()
 	p.WithMaxHeight(MaxItemsToShow)
 	p.WithFilterStrategy(FilterStrategy)
+	p.WithMouse(MouseSupport)
 	p.WithEmptyMessage("No SSO Sessions Found")
 	p.WithTitle("Pick SSO Session")
 	p.WithHeaders("SSO Session", "Region", "SSO Start URL", "Expires In")
+	withSavedSort(p, "Pick SSO Session")
 	p.AddAction(keys.Tab, "tab", "view cached")
 	for _, session := range sessions {
 		expires := "-"
@@ -93,7 +125,89 @@ func SelectSession(sessions credentials.Sessions) (string, string, error) {
 	if selection == nil {
 		return "", "", ErrNotPickedSession
 	}
-	return selection.Value.(string), "", nil
+	return selection.Value, "", nil
+}
+
+// Fields are label and value pairs, pairs without a value are left out.
+func previewFields(fields ...string) []string {
+	labelWidth := 0
+	for i := 0; i+1 < len(fields); i += 2 {
+		labelWidth = max(labelWidth, len(fields[i])+1)
+	}
+	lines := []string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if value := strings.TrimSpace(fields[i+1]); value != "" && value != "-" {
+			lines = append(lines, fmt.Sprintf("%-*s  %s", labelWidth, fields[i]+":", value))
+		}
+	}
+	return lines
+}
+
+func previewTime(t time.Time, suffix string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s %s)", t.Local().Format("2006-01-02 15:04"), humanizeDuration(time.Since(t).Abs()), suffix)
+}
+
+func accountPreview(account credentials.Account, alias string) func() []string {
+	return func() []string {
+		return previewFields(
+			"Account ID", account.Id,
+			"Name", account.Name,
+			"Alias", alias,
+			"Email", account.Email,
+		)
+	}
+}
+
+func roleCredentialsPreview(role credentials.Role, alias string) func() []string {
+	return func() []string {
+		accessKeyId, expires := "", ""
+		if role.Credentials != nil {
+			accessKeyId = role.Credentials.AccessKeyId
+			expires = "expired"
+			if !role.Credentials.IsExpired() {
+				expires = previewTime(role.Credentials.Expiration, "left")
+			}
+		}
+		return previewFields(
+			"SSO Session", role.SessionName,
+			"Account ID", role.AccountId,
+			"Alias", alias,
+			"Role Name", role.Name,
+			"Region", role.Region,
+			"Access Key ID", accessKeyId,
+			"Expires", expires,
+		)
+	}
+}
+
+func instancePreview(instance credentials.Instance) func() []string {
+	return func() []string {
+		profile := instance.InstanceProfile
+		if _, name, found := strings.Cut(profile, ":instance-profile/"); found {
+			profile = name
+		}
+		lines := previewFields(
+			"Instance ID", instance.Id,
+			"Zone", instance.AvailabilityZone,
+			"VPC", instance.VpcId,
+			"Subnet", instance.SubnetId,
+			"Profile", profile,
+			"Launched", previewTime(instance.LaunchTime, "ago"),
+			"Platform", instance.PlatformName,
+		)
+		tags := []string{}
+		for key := range instance.Tags {
+			tags = append(tags, key)
+		}
+		sort.Strings(tags)
+		for _, key := range tags {
+			lines = append(lines, fmt.Sprintf("%s = %s", key, instance.Tags[key]))
+		}
+		return lines
+	}
 }
 
 func isChanClosed(ch <-chan []credentials.Account) bool {
@@ -115,12 +229,15 @@ func isErrorChanClosed(ch <-chan error) bool {
 }
 
 func SelectAccount(session *credentials.Session, accountAliases map[string]string) (string, string, error) {
-	p := picker.NewPicker()
+	p := picker.NewPicker[string]()
 	p.WithMaxHeight(MaxItemsToShow)
 	p.WithFilterStrategy(FilterStrategy)
+	p.WithMouse(MouseSupport)
 	p.WithEmptyMessage("No Accounts Found")
 	p.WithTitle("Pick Account")
 	p.WithHeaders("Account ID", "Alias/Name", "Email")
+	withSavedSort(p, "Pick Account")
+	p.WithPreview(ShowPreview)
 	p.AddAction(keys.Esc, "esc", "go back")
 	p.SetLoading(true)
 
@@ -148,24 +265,27 @@ func SelectAccount(session *credentials.Session, accountAliases map[string]strin
 				if !ok {
 					break loop
 				}
-				for _, account := range accounts {
-					name := account.Name
-					if val, ok := accountAliases[account.Id]; ok {
-						if strings.TrimSpace(val) != "" {
-							name = val
+				p.Mutate(func() {
+					for _, account := range accounts {
+						name := account.Name
+						if val, ok := accountAliases[account.Id]; ok {
+							if strings.TrimSpace(val) != "" {
+								name = val
+							}
 						}
+						p.AddOption(account.Id, account.Id, name, account.Email)
+						p.SetOptionPreview(account.Id, accountPreview(account, accountAliases[account.Id]))
 					}
-					p.AddOption(account.Id, account.Id, name, account.Email)
-				}
+				})
 			case err := <-errCh:
 				if err != nil {
 					break loop
 				}
 			}
-			p.Update()
 		}
-		p.SetLoading(false)
-		p.Update()
+		p.Mutate(func() {
+			p.SetLoading(false)
+		})
 	}()
 
 	selection, firedKeyCode := p.Pick("")
@@ -178,17 +298,20 @@ func SelectAccount(session *credentials.Session, accountAliases map[string]strin
 	if selection == nil {
 		return "", "", ErrNotPickedAccount
 	}
-	return selection.Value.(string), "", nil
+	return selection.Value, "", nil
 }
 
 func SelectRole(roles credentials.Roles) (string, string, error) {
 	now := time.Now()
-	p := picker.NewPicker()
+	p := picker.NewPicker[string]()
 	p.WithMaxHeight(MaxItemsToShow)
 	p.WithFilterStrategy(FilterStrategy)
+	p.WithMouse(MouseSupport)
 	p.WithEmptyMessage("No Roles Found")
 	p.WithTitle("Pick Role")
 	p.WithHeaders("Role Name", "Expires In")
+	withSavedSort(p, "Pick Role")
+	p.WithPreview(ShowPreview)
 	p.AddAction(keys.Esc, "esc", "go back")
 	for _, role := range roles {
 		expires := "-"
@@ -196,6 +319,7 @@ func SelectRole(roles credentials.Roles) (string, string, error) {
 			expires = fmt.Sprintf("%.f mins", role.Credentials.Expiration.Sub(now).Minutes())
 		}
 		p.AddOption(role.Name, role.Name, expires)
+		p.SetOptionPreview(role.Name, roleCredentialsPreview(role, ""))
 	}
 	selection, firedKeyCode := p.Pick("")
 	if firedKeyCode != nil && *firedKeyCode == keys.Esc {
@@ -204,7 +328,7 @@ func SelectRole(roles credentials.Roles) (string, string, error) {
 	if selection == nil {
 		return "", "", ErrNotPickedRole
 	}
-	return selection.Value.(string), "", nil
+	return selection.Value, "", nil
 }
 
 func cutOff(s string, n int) string {
@@ -222,119 +346,426 @@ func cutOffInMiddle(s string, n int) string {
 	return s
 }
 
-func SelectInstance(role *credentials.Role, region, initialFilter string, instanceColTags []string) (string, string, error) {
-	instances, err := role.GetManagedInstances(region)
+func forEachRegion(regions []string, fn func(region string)) {
+	parallelism := MaxConcurrentRequests
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	semaphore := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			fn(region)
+		}(region)
+	}
+	wg.Wait()
+}
+
+type regionInstances struct {
+	region    string
+	instances credentials.Instances
+	cached    bool
+	complete  bool
+	err       error
+	warning   error
+}
+
+// Instances are listed through the cache and the EC2 API of a role, which
+// tests stub with instances of their own.
+type instanceFetcher interface {
+	GetCachedInstances(region string, filters credentials.InstanceFilters) (credentials.Instances, time.Time, error)
+	SaveCachedInstances(region string, filters credentials.InstanceFilters, instances credentials.Instances) error
+	GetManagedInstancesStream(region string, filters credentials.InstanceFilters, onInstances func(credentials.Instances)) error
+}
+
+func fetchRegionInstances(role instanceFetcher, region string, filters credentials.InstanceFilters, force bool, updates chan<- regionInstances, done <-chan struct{}) {
+	send := func(update regionInstances) {
+		select {
+		case updates <- update:
+		case <-done:
+		}
+	}
+	cached, fetchedAt, err := role.GetCachedInstances(region, filters)
+	if err == nil && cached != nil {
+		if !force && time.Since(fetchedAt) < InstanceCacheTTL {
+			send(regionInstances{region: region, instances: cached, cached: true, complete: true})
+			return
+		}
+		send(regionInstances{region: region, instances: cached, cached: true})
+	}
+	fresh := credentials.Instances{}
+	err = role.GetManagedInstancesStream(region, filters, func(page credentials.Instances) {
+		fresh = append(fresh, page...)
+		send(regionInstances{region: region, instances: page})
+	})
+	// Instances listed without their instance information aren't cached, so
+	// they are described again once the permission is granted.
+	var warning error
+	if errors.Is(err, credentials.ErrInstanceInformation) {
+		warning, err = err, nil
+	}
 	if err != nil {
-		return "", "", err
+		send(regionInstances{region: region, err: err})
+		return
+	}
+	if warning == nil {
+		role.SaveCachedInstances(region, filters, fresh)
 	}
+	send(regionInstances{region: region, instances: fresh, complete: true, warning: warning})
+}
+
+func humanizeDuration(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%.f mins", d.Minutes())
+	}
+	if d < 48*time.Hour {
+		return fmt.Sprintf("%.f hours", d.Hours())
+	}
+	return fmt.Sprintf("%.f days", d.Hours()/24)
+}
+
+// The humanized times don't sort the way they read, so they are sorted by
+// their age in seconds instead.
+func instanceSortKeys(instance credentials.Instance, instanceColTags []string, withRegion bool) []string {
+	keys := []string{""}
+	if withRegion {
+		keys = append(keys, "")
+	}
+	for _, tag := range instanceColTags {
+		key := ""
+		if tag == "Last Ping" && !instance.LastPingDateTime.IsZero() {
+			key = fmt.Sprintf("%d", int64(time.Since(instance.LastPingDateTime).Seconds()))
+		}
+		if tag == "Launch Time" && !instance.LaunchTime.IsZero() {
+			key = fmt.Sprintf("%d", int64(time.Since(instance.LaunchTime).Seconds()))
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func instanceColumns(instance credentials.Instance, instanceColTags []string, withRegion bool) []string {
+	values := []string{instance.Id}
+	if withRegion {
+		values = append(values, instance.Region)
+	}
+	for _, tag := range instanceColTags {
+		value := "-"
+		switch tag {
+		case "Instance Type":
+			value = instance.InstanceType
+		case "Private IP":
+			value = instance.PrivateIpAddress
+		case "Public IP":
+			value = instance.PublicIpAddress
+		case "Ping Status":
+			value = instance.PingStatus
+		case "Platform":
+			value = instance.PlatformName
+		case "Agent Version":
+			value = instance.AgentVersion
+		case "Last Ping":
+			value = "-"
+			if !instance.LastPingDateTime.IsZero() {
+				value = fmt.Sprintf("%s ago", humanizeDuration(time.Since(instance.LastPingDateTime)))
+			}
+		case "Launch Time":
+			value = "-"
+			if !instance.LaunchTime.IsZero() {
+				value = fmt.Sprintf("%s ago", humanizeDuration(time.Since(instance.LaunchTime)))
+			}
+		default:
+			value = instance.Tags[tag]
+		}
+		value = cutOffInMiddle(value, 36)
+		values = append(values, value)
+	}
+	return values
+}
+
+func SelectInstance(role *credentials.Role, regions []string, filters credentials.InstanceFilters, initialFilter string, instanceColTags []string) (*credentials.Instance, string, error) {
+	instances, action, err := selectInstances(role, regions, filters, initialFilter, instanceColTags, false)
+	if len(instances) < 1 {
+		return nil, action, err
+	}
+	return &instances[0], action, err
+}
+
+func SelectInstances(role *credentials.Role, regions []string, filters credentials.InstanceFilters, initialFilter string, instanceColTags []string) (credentials.Instances, string, error) {
+	return selectInstances(role, regions, filters, initialFilter, instanceColTags, true)
+}
+
+func selectInstances(role instanceFetcher, regions []string, filters credentials.InstanceFilters, initialFilter string, instanceColTags []string, multiSelect bool) (credentials.Instances, string, error) {
+	multiRegion := len(regions) > 1
 	cols := []string{"Instance ID"}
+	if multiRegion {
+		cols = append(cols, "Region")
+	}
 	for _, tag := range instanceColTags {
 		cols = append(cols, tag)
 	}
-	p := picker.NewPicker()
+	title := fmt.Sprintf("Pick EC2 Instance (%s)", strings.Join(regions, ", "))
+	if multiRegion {
+		title = fmt.Sprintf("Pick EC2 Instance (%d regions)", len(regions))
+	}
+
+	known := map[string]credentials.Instance{}
+	updates := make(chan regionInstances)
+	done := make(chan struct{})
+	failedRegions := []string{}
+	unmanagedRegions := []string{}
+	mutex := sync.Mutex{}
+	pending := 0
+	var firstErr error
+
+	p := picker.NewPicker[string]()
 	p.WithMaxHeight(MaxItemsToShow)
 	p.WithFilterStrategy(FilterStrategy)
+	p.WithMouse(MouseSupport)
 	p.WithEmptyMessage("No Instances Found")
-	p.WithTitle(fmt.Sprintf("Pick EC2 Instance (%s)", region))
+	p.WithTitle(title)
 	p.WithHeaders(cols...)
+	withSavedSort(p, "Pick EC2 Instance")
+	p.WithPreview(ShowPreview)
+	if multiSelect {
+		p.WithMultiSelect()
+	}
+
+	revalidate := func(force bool) {
+		p.Mutate(func() {
+			mutex.Lock()
+			pending += len(regions)
+			p.SetLoading(true)
+			mutex.Unlock()
+		})
+		go forEachRegion(regions, func(region string) {
+			fetchRegionInstances(role, region, filters, force, updates, done)
+		})
+	}
+
+	upsert := func(instance credentials.Instance) {
+		_, exists := known[instance.Id]
+		if instance.IsOffline() && HideOfflineInstances {
+			if exists {
+				delete(known, instance.Id)
+				p.RemoveOption(instance.Id)
+			}
+			return
+		}
+		values := instanceColumns(instance, instanceColTags, multiRegion)
+		if exists {
+			p.UpdateOption(instance.Id, values...)
+		} else {
+			p.AddOption(instance.Id, values...)
+		}
+		p.SetOptionDimmed(instance.Id, instance.IsOffline())
+		p.SetOptionSortKeys(instance.Id, instanceSortKeys(instance, instanceColTags, multiRegion)...)
+		p.SetOptionPreview(instance.Id, instancePreview(instance))
+		known[instance.Id] = instance
+	}
+
 	p.AddAction(keys.Esc, "esc", "go back")
 	p.AddAction(keys.F1, "f1", "pick region")
-	p.AddAction(keys.F2, "f2", "refresh")
-	for _, instance := range instances {
-		values := []string{instance.Id}
-		for _, tag := range instanceColTags {
-			value := "-"
-			switch tag {
-			case "Instance Type":
-				value = instance.InstanceType
-			case "Private IP":
-				value = instance.PrivateIpAddress
-			case "Public IP":
-				value = instance.PublicIpAddress
-			default:
-				value = instance.Tags[tag]
+	p.AddCallbackAction(keys.F2, "f2", "refresh", func() {
+		revalidate(true)
+	})
+	if multiRegion {
+		p.AddAction(keys.F3, "f3", "single region")
+	} else {
+		p.AddAction(keys.F3, "f3", "all regions")
+	}
+
+	applyUpdate := func(update regionInstances) {
+		if update.err != nil {
+			if firstErr == nil {
+				firstErr = update.err
 			}
-			value = cutOffInMiddle(value, 36)
-			values = append(values, value)
+			failedRegions = append(failedRegions, update.region)
+			p.WithEmptyMessage(fmt.Sprintf("Failed to query instances in %s", strings.Join(failedRegions, ", ")))
+			pending--
+		}
+		if update.warning != nil && !slices.Contains(unmanagedRegions, update.region) {
+			unmanagedRegions = append(unmanagedRegions, update.region)
+			p.SetStatus(fmt.Sprintf("ping status unknown in %s", strings.Join(unmanagedRegions, ", ")))
 		}
-		p.AddOption(instance.Id, values...)
+		for _, instance := range update.instances {
+			upsert(instance)
+		}
+		if update.complete {
+			seen := map[string]bool{}
+			for _, instance := range update.instances {
+				seen[instance.Id] = true
+			}
+			for id, instance := range known {
+				if instance.Region == update.region && !seen[id] {
+					delete(known, id)
+					p.RemoveOption(id)
+				}
+			}
+			pending--
+		}
+		p.SetLoading(pending > 0)
 	}
-	selection, firedKeyCode := p.Pick(initialFilter)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case update := <-updates:
+				p.Mutate(func() {
+					mutex.Lock()
+					defer mutex.Unlock()
+					applyUpdate(update)
+				})
+			}
+		}
+	}()
+
+	revalidate(false)
+	selections, firedKeyCode := p.PickMany(initialFilter)
+	close(done)
+	mutex.Lock()
+	defer mutex.Unlock()
+
 	if firedKeyCode != nil && *firedKeyCode == keys.Esc {
-		return "", "back", nil
+		return nil, "back", nil
 	}
 	if firedKeyCode != nil && *firedKeyCode == keys.F1 {
-		return "", "pick-region", nil
+		return nil, "pick-region", nil
 	}
-	if firedKeyCode != nil && *firedKeyCode == keys.F2 {
-		return "", "refresh", nil
+	if firedKeyCode != nil && *firedKeyCode == keys.F3 {
+		return nil, "toggle-regions", nil
 	}
-	if selection == nil {
-		return "", "", ErrNotPickedInstance
+	if len(selections) < 1 {
+		if firstErr != nil && len(known) < 1 {
+			return nil, "", firstErr
+		}
+		return nil, "", ErrNotPickedInstance
+	}
+	instances := credentials.Instances{}
+	for _, selection := range selections {
+		if instance, ok := known[selection.Value]; ok {
+			instances = append(instances, instance)
+		}
 	}
-	return selection.Value.(string), "", nil
+	if len(instances) < 1 {
+		return nil, "", ErrNotPickedInstance
+	}
+	return instances, "", nil
 }
 
-func SelectRegion(initialFilter string) (string, string, error) {
-	regions := [][]string{
-		{"us-east-1", "US East (N. Virginia)"},
-		{"us-east-2", "US East (Ohio)"},
-		{"us-west-1", "US West (N. California)"},
-		{"us-west-2", "US West (Oregon)"},
-		{"af-south-1", "Africa (Cape Town)"},
-		{"ap-east-1", "Asia Pacific (Hong Kong)"},
-		{"ap-south-1", "Asia Pacific (Mumbai)"},
-		{"ap-southeast-1", "Asia Pacific (Singapore)"},
-		{"ap-southeast-2", "Asia Pacific (Sydney)"},
-		{"ap-northeast-1", "Asia Pacific (Tokyo)"},
-		{"ap-northeast-2", "Asia Pacific (Seoul)"},
-		{"ca-central-1", "Canada (Central)"},
-		{"eu-central-1", "EU (Frankfurt)"},
-		{"eu-west-1", "EU (Ireland)"},
-		{"eu-west-2", "EU (London)"},
-		{"eu-south-1", "EU (Milan)"},
-		{"eu-west-3", "EU (Paris)"},
-		{"eu-north-1", "EU (Stockholm)"},
-		{"me-south-1", "Middle East (Bahrain)"},
-		{"sa-east-1", "South America (Sao Paulo)"},
-		{"ap-northeast-3", "Asia Pacific (Osaka-Local)"},
-		{"us-gov-west-1", "AWS GovCloud (US-West)"},
-		{"cn-northwest-1", "China (Ningxia)"},
-		{"cn-north-1", "China (Beijing)"},
-		{"us-gov-east-1", "AWS GovCloud (US-East)"},
-	}
-	p := picker.NewPicker()
+// Counts come from the instance cache shared with the instance picker, and
+// regions whose cache is missing or stale are revalidated in the background.
+func SelectRegion(role *credentials.Role, currentRegion string, filters credentials.InstanceFilters) (string, string, error) {
+	regions, err := role.GetRegions(currentRegion)
+	if err != nil || len(regions) < 1 {
+		regions = credentials.KnownRegions
+	}
+	p := picker.NewPicker[string]()
 	p.WithMaxHeight(MaxItemsToShow)
 	p.WithFilterStrategy(FilterStrategy)
+	p.WithMouse(MouseSupport)
 	p.WithEmptyMessage("No Regions Found")
 	p.WithTitle("Pick Region")
-	p.WithHeaders("Region", "Name")
+	p.WithHeaders("Region", "Name", "Opt-In Status", "Instances")
+	withSavedSort(p, "Pick Region")
 	p.AddAction(keys.Esc, "esc", "go back")
-	for _, regionArray := range regions {
-		p.AddOption(regionArray[0], regionArray[0], regionArray[1])
+	for i, region := range regions {
+		if region.Name == currentRegion {
+			p.WithInitialIndex(i)
+		}
+		p.AddOption(region.Name, region.Name, region.Description, region.OptInStatus, "-")
 	}
+
+	enabled := regions.Enabled()
+	updates := make(chan regionInstances)
+	done := make(chan struct{})
+	p.SetLoading(len(enabled) > 0)
+
+	go func() {
+		enabledNames := []string{}
+		for _, region := range enabled {
+			enabledNames = append(enabledNames, region.Name)
+		}
+		forEachRegion(enabledNames, func(region string) {
+			fetchRegionInstances(role, region, filters, false, updates, done)
+		})
+		close(updates)
+	}()
+
+	go func() {
+		counted := map[string]bool{}
+		for {
+			select {
+			case <-done:
+				return
+			case update, ok := <-updates:
+				if !ok {
+					p.Mutate(func() {
+						p.SetLoading(false)
+					})
+					return
+				}
+				// Pages streamed while revalidating only add up to a count
+				// once the region is complete.
+				if update.err == nil && !update.cached && !update.complete {
+					continue
+				}
+				if update.err != nil && counted[update.region] {
+					continue
+				}
+				region := regions.FindByName(update.region)
+				count := "none"
+				if update.err != nil {
+					count = "error"
+				} else if len(update.instances) > 0 {
+					count = fmt.Sprintf("%d instances", len(update.instances))
+				}
+				counted[update.region] = update.err == nil
+				p.Mutate(func() {
+					p.UpdateOption(region.Name, region.Name, region.Description, region.OptInStatus, count)
+					// Regions without instances are dimmed, so the ones
+					// that have some stand out.
+					p.SetOptionDimmed(region.Name, count == "none")
+				})
+			}
+		}
+	}()
+
 	selection, firedKeyCode := p.Pick("")
+	close(done)
+
 	if firedKeyCode != nil && *firedKeyCode == keys.Esc {
 		return "", "back", nil
 	}
 	if selection == nil {
-		return "", "", ErrNotPickedInstance
+		return "", "", ErrNotPickedRegion
 	}
-	return selection.Value.(string), "", nil
+	return selection.Value, "", nil
 }
 
-func SelectRolesCredentials(accountAliases map[string]string) (*credentials.Role, string, error) {
+// Every selected role is returned, so several can be refreshed at once.
+func SelectRolesCredentials(accountAliases map[string]string) (credentials.Roles, string, error) {
 	now := time.Now()
 	roles, err := credentials.GetSavedRolesWithCredentials()
 	if err != nil {
 		return nil, "", err
 	}
-	p := picker.NewPicker()
+	p := picker.NewPicker[credentials.Role]()
 	p.WithMaxHeight(MaxItemsToShow)
 	p.WithFilterStrategy(FilterStrategy)
+	p.WithMouse(MouseSupport)
 	p.WithEmptyMessage("No Role Credentials Found")
 	p.WithTitle("Pick Role Credentials")
 	p.WithHeaders("SSO Session", "Region", "Account ID", "Alias", "Role Name", "Expires In")
+	withSavedSort(p, "Pick Role Credentials")
+	p.WithPreview(ShowPreview)
+	p.WithMultiSelect()
 	p.AddAction(keys.Tab, "tab", "pick session")
 	p.AddAction(keys.Delete, "del", "delete")
 	for _, role := range roles {
@@ -349,21 +780,30 @@ func SelectRolesCredentials(accountAliases map[string]string) (*credentials.Role
 			}
 		}
 		p.AddOption(role, role.SessionName, role.Region, role.AccountId, alias, role.Name, expires)
+		p.SetOptionPreview(role, roleCredentialsPreview(role, alias))
 	}
-	selection, firedKeyCode := p.Pick("")
+	selections, firedKeyCode := p.PickMany("")
 	if firedKeyCode != nil && *firedKeyCode == keys.Tab {
 		return nil, "toggle-view", nil
 	}
+	// Deleting is done here since several roles can be selected at once.
 	if firedKeyCode != nil && *firedKeyCode == keys.Delete {
-		if selection != nil {
-			selected := selection.Value.(credentials.Role)
-			return &selected, "delete", nil
+		for _, selection := range selections {
+			selected := selection.Value
+			if selected.Credentials != nil {
+				if err := selected.Credentials.DeleteCache(selected.SessionName, selected.CacheKey()); err != nil {
+					return nil, "", err
+				}
+			}
 		}
 		return nil, "delete", nil
 	}
-	if selection == nil {
+	if len(selections) < 1 {
 		return nil, "", ErrNotPickedRoleCredentials
 	}
-	selected := selection.Value.(credentials.Role)
-	return &selected, "", nil
+	selected := credentials.Roles{}
+	for _, selection := range selections {
+		selected = append(selected, selection.Value)
+	}
+	return selected, "", nil
 }
@@ -0,0 +1,249 @@
+package tui
+
+import (
+	"flag"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/null93/aws-knox/pkg/vterm"
+	"github.com/null93/aws-knox/sdk/credentials"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const waitTimeout = 2 * time.Second
+
+func headlessTerminal(t *testing.T) *vterm.Terminal {
+	t.Helper()
+	term := vterm.New(100, 20)
+	restore := Headless(term)
+	t.Cleanup(func() {
+		term.Close()
+		restore()
+	})
+	return term
+}
+
+func waitFor(t *testing.T, term *vterm.Terminal, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		if err := term.WaitFor(text, waitTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func matchGolden(t *testing.T, term *vterm.Terminal, name string) {
+	t.Helper()
+	if err := term.MatchGolden("testdata/"+name+".golden", *update); err != nil {
+		t.Error(err)
+	}
+}
+
+type selection struct {
+	value  string
+	action string
+	err    error
+}
+
+func expectSelection(t *testing.T, selected <-chan selection, expected selection) {
+	t.Helper()
+	select {
+	case s := <-selected:
+		if s != expected {
+			t.Errorf("selected %+v, want %+v", s, expected)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for the selection")
+	}
+}
+
+func TestSelectSession(t *testing.T) {
+	term := headlessTerminal(t)
+	sessions := credentials.Sessions{
+		{Name: "production", Region: "us-east-1", StartUrl: "https://prod.awsapps.com/start", ClientToken: &credentials.ClientToken{ExpiresAt: time.Now().Add(45*time.Minute + 20*time.Second)}},
+		{Name: "staging", Region: "eu-west-1", StartUrl: "https://staging.awsapps.com/start"},
+	}
+	selected := make(chan selection, 1)
+	go func() {
+		value, action, err := SelectSession(sessions)
+		selected <- selection{value, action, err}
+	}()
+	waitFor(t, term, "Pick SSO Session", "staging")
+	matchGolden(t, term, "select_session")
+	term.Press(vterm.Down, vterm.Enter)
+	expectSelection(t, selected, selection{"staging", "", nil})
+
+	go func() {
+		value, action, err := SelectSession(sessions)
+		selected <- selection{value, action, err}
+	}()
+	waitFor(t, term, "Pick SSO Session")
+	term.Press(vterm.Tab)
+	expectSelection(t, selected, selection{"", "toggle-view", nil})
+}
+
+func TestSelectRole(t *testing.T) {
+	term := headlessTerminal(t)
+	showPreview, pickerSorts, savePickerSort := ShowPreview, PickerSorts, SavePickerSort
+	t.Cleanup(func() { ShowPreview, PickerSorts, SavePickerSort = showPreview, pickerSorts, savePickerSort })
+	ShowPreview = true
+	PickerSorts = map[string]string{"pick role": "Role Name:asc"}
+	saved := map[string]string{}
+	SavePickerSort = func(title string, sort string) { saved[title] = sort }
+	roles := credentials.Roles{
+		{Name: "ReadOnly", AccountId: "123456789012", Region: "us-east-1", SessionName: "production", Credentials: &credentials.RoleCredentials{AccessKeyId: "ASIAEXPIRED", Expiration: time.Now().Add(-time.Hour)}},
+		{Name: "Admin", AccountId: "123456789012", Region: "us-east-1", SessionName: "production"},
+	}
+	selected := make(chan selection, 1)
+	go func() {
+		value, action, err := SelectRole(roles)
+		selected <- selection{value, action, err}
+	}()
+	waitFor(t, term, "Pick Role", "Role Name:      Admin")
+	matchGolden(t, term, "select_role")
+	term.Press(vterm.Down)
+	waitFor(t, term, "Expires:        expired")
+	matchGolden(t, term, "select_role_expired")
+	term.Press(vterm.CtrlS)
+	waitFor(t, term, "Role Name ▼")
+	term.Press(vterm.Enter)
+	expectSelection(t, selected, selection{"ReadOnly", "", nil})
+	if saved["pick role"] != "Role Name:desc" {
+		t.Errorf("saved sorts are %q", saved)
+	}
+
+	go func() {
+		value, action, err := SelectRole(roles)
+		selected <- selection{value, action, err}
+	}()
+	waitFor(t, term, "Role Name ▼")
+	term.Press(vterm.Esc)
+	expectSelection(t, selected, selection{"", "back", nil})
+
+	go func() {
+		value, action, err := SelectRole(roles)
+		selected <- selection{value, action, err}
+	}()
+	waitFor(t, term, "Pick Role")
+	term.Press(vterm.CtrlC)
+	expectSelection(t, selected, selection{"", "", ErrNotPickedRole})
+}
+
+// Instances are streamed page by page, once the test releases a fetch, and
+// saved instances become the cache for the next fetch of the region.
+type stubFetcher struct {
+	mutex     sync.Mutex
+	cached    map[string]credentials.Instances
+	fetchedAt time.Time
+	pages     map[string][][]credentials.Instances
+	fetches   map[string]int
+	release   chan struct{}
+}
+
+func (s *stubFetcher) GetCachedInstances(region string, filters credentials.InstanceFilters) (credentials.Instances, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cached[region], s.fetchedAt, nil
+}
+
+func (s *stubFetcher) SaveCachedInstances(region string, filters credentials.InstanceFilters, instances credentials.Instances) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cached[region] = instances
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func (s *stubFetcher) GetManagedInstancesStream(region string, filters credentials.InstanceFilters, onInstances func(credentials.Instances)) error {
+	s.mutex.Lock()
+	pages := s.pages[region][s.fetches[region]]
+	s.fetches[region]++
+	s.mutex.Unlock()
+	<-s.release
+	for _, page := range pages {
+		onInstances(page)
+	}
+	return nil
+}
+
+func onlineInstance(id string, region string, name string) credentials.Instance {
+	return credentials.Instance{Id: id, Region: region, PingStatus: "Online", Tags: map[string]string{"Name": name}}
+}
+
+type instancesSelection struct {
+	instances credentials.Instances
+	action    string
+	err       error
+}
+
+func TestSelectInstances(t *testing.T) {
+	term := headlessTerminal(t)
+	pickerSorts := PickerSorts
+	t.Cleanup(func() { PickerSorts = pickerSorts })
+	PickerSorts = map[string]string{"pick ec2 instance": "Instance ID:asc"}
+	fetcher := &stubFetcher{
+		cached: map[string]credentials.Instances{
+			"us-east-1": {
+				onlineInstance("i-1", "us-east-1", "web-old"),
+				onlineInstance("i-2", "us-east-1", "web-2"),
+				onlineInstance("i-9", "us-east-1", "retired"),
+			},
+		},
+		fetchedAt: time.Now().Add(-time.Hour),
+		pages: map[string][][]credentials.Instances{
+			"us-east-1": {
+				{{onlineInstance("i-1", "us-east-1", "web-1")}, {onlineInstance("i-2", "us-east-1", "web-2"), onlineInstance("i-3", "us-east-1", "web-3")}},
+				{{onlineInstance("i-1", "us-east-1", "web-1"), onlineInstance("i-3", "us-east-1", "web-3")}},
+			},
+			"eu-west-1": {
+				{{onlineInstance("i-4", "eu-west-1", "api-1")}},
+				{{onlineInstance("i-4", "eu-west-1", "api-1")}, {onlineInstance("i-5", "eu-west-1", "api-2")}, {onlineInstance("i-6", "eu-west-1", "api-3")}},
+			},
+		},
+		fetches: map[string]int{},
+		release: make(chan struct{}),
+	}
+	selected := make(chan instancesSelection, 1)
+	go func() {
+		instances, action, err := selectInstances(fetcher, []string{"us-east-1", "eu-west-1"}, nil, "", []string{"Name"}, true)
+		selected <- instancesSelection{instances, action, err}
+	}()
+	waitFor(t, term, "Pick EC2 Instance (2 regions)", "retired", "3/3 items (loading)")
+	matchGolden(t, term, "select_instances_cached")
+
+	fetcher.release <- struct{}{}
+	fetcher.release <- struct{}{}
+	waitFor(t, term, "4/4 items •")
+	matchGolden(t, term, "select_instances")
+	term.Press(vterm.Space, vterm.Down, vterm.Space)
+	waitFor(t, term, "2 selected")
+
+	term.Press(vterm.F2)
+	waitFor(t, term, "(loading)")
+	fetcher.release <- struct{}{}
+	fetcher.release <- struct{}{}
+	waitFor(t, term, "5/5 items •", "1 selected")
+	matchGolden(t, term, "select_instances_refreshed")
+	term.Press(vterm.Enter)
+
+	select {
+	case s := <-selected:
+		expected := credentials.Instances{onlineInstance("i-1", "us-east-1", "web-1")}
+		if s.err != nil || s.action != "" || !reflect.DeepEqual(s.instances, expected) {
+			t.Errorf("selected %+v, want %+v", s, expected)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for the selection")
+	}
+	fetcher.mutex.Lock()
+	defer fetcher.mutex.Unlock()
+	if ids := fetcher.cached["us-east-1"]; len(ids) != 2 || ids[0].Id != "i-1" || ids[1].Id != "i-3" {
+		t.Errorf("cached %+v in us-east-1, want i-1 and i-3", ids)
+	}
+	if ids := fetcher.cached["eu-west-1"]; len(ids) != 3 {
+		t.Errorf("cached %+v in eu-west-1, want i-4, i-5 and i-6", ids)
+	}
+}